@@ -1,20 +1,20 @@
 package sx
 
 import "io"
-import "bytes"
 import "encoding/base64"
 
+// Decodes base64 written to it in arbitrary chunks, writing the decoded data
+// to sink. Whitespace is ignored. Characters are buffered until a complete
+// four-character quantum is available, so chunks need not be aligned.
 type writeDecoder struct {
-	sink   io.Writer
-	b64dec io.Reader
-	b64sr  switchableReader
-	err    error
+	sink io.Writer
+	pend []byte // characters not yet forming a complete quantum
+	buf  []byte
+	err  error
 }
 
 func newWriteDecoder(sink io.Writer) *writeDecoder {
-	wd := &writeDecoder{sink: sink}
-	wd.b64dec = base64.NewDecoder(base64.StdEncoding, &filteringReader{&wd.b64sr})
-	return wd
+	return &writeDecoder{sink: sink}
 }
 
 func (wd *writeDecoder) Write(b []byte) (int, error) {
@@ -22,49 +22,45 @@ func (wd *writeDecoder) Write(b []byte) (int, error) {
 		return 0, wd.err
 	}
 
-	wd.b64sr.Reader = bytes.NewReader(b)
-	n, err := io.Copy(wd.sink, wd.b64dec)
-	if err != nil {
-		wd.err = err
-		return int(n), err
+	for _, c := range b {
+		if c != '\r' && c != '\n' && c != ' ' && c != '\t' {
+			wd.pend = append(wd.pend, c)
+		}
 	}
 
-	return len(b), nil
-}
-
-type switchableReader struct {
-	Reader io.Reader
-}
+	n := len(wd.pend) / 4 * 4
+	if n == 0 {
+		return len(b), nil
+	}
 
-func (sr *switchableReader) Read(b []byte) (int, error) {
-	if sr.Reader == nil {
-		return 0, io.EOF
+	if need := base64.StdEncoding.DecodedLen(n); cap(wd.buf) < need {
+		wd.buf = make([]byte, need)
+	}
+	m, err := base64.StdEncoding.Decode(wd.buf[:cap(wd.buf)], wd.pend[:n])
+	if err != nil {
+		wd.err = err
+		return 0, err
 	}
+	wd.pend = append(wd.pend[:0], wd.pend[n:]...)
 
-	return sr.Reader.Read(b)
-}
+	_, err = wd.sink.Write(wd.buf[:m])
+	if err != nil {
+		wd.err = err
+		return 0, err
+	}
 
-type filteringReader struct {
-	wrapped io.Reader
+	return len(b), nil
 }
 
-func (r *filteringReader) Read(p []byte) (int, error) {
-	n, err := r.wrapped.Read(p)
-	for n > 0 {
-		offset := 0
-		for i, b := range p[:n] {
-			if b != '\r' && b != '\n' && b != ' ' && b != '\t' {
-				if i != offset {
-					p[offset] = b
-				}
-				offset++
-			}
-		}
-		if offset > 0 {
-			return offset, err
-		}
-		// Previous buffer entirely whitespace, read again
-		n, err = r.wrapped.Read(p)
+// Signals the end of the base64 data. Returns an error if it ended partway
+// through a quantum.
+func (wd *writeDecoder) Close() error {
+	if wd.err != nil {
+		return wd.err
+	}
+	if len(wd.pend) != 0 {
+		wd.err = io.ErrUnexpectedEOF
+		return wd.err
 	}
-	return n, err
+	return nil
 }
@@ -0,0 +1,188 @@
+// Command sx converts, formats, validates and queries S-expressions.
+//
+// Usage:
+//
+//   sx [flags] [file...]
+//
+// Input is read from the named files, or from stdin if none are given. All
+// of the syntaxes accepted by the SX format are accepted on input. Flags:
+//
+//   -o advanced|canonical|transport
+//        output encoding (default advanced)
+//   -p   pretty-print (advanced output only)
+//   -c   check syntax only; report errors and produce no output
+//   -s selector
+//        output only the values selected by a Q1bsyt selector, e.g. "b y"
+//
+// Syntax errors are reported with the line and column at which they occur.
+package main
+
+import "bytes"
+import "encoding/base64"
+import "flag"
+import "fmt"
+import "io"
+import "io/ioutil"
+import "os"
+import "github.com/hlandau/sx"
+
+var outFlag = flag.String("o", "advanced", "output encoding: advanced, canonical or transport")
+var prettyFlag = flag.Bool("p", false, "pretty-print (advanced output only)")
+var checkFlag = flag.Bool("c", false, "check syntax only")
+var selectorFlag = flag.String("s", "", "output only the values selected by the selector")
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: sx [flags] [file...]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	switch *outFlag {
+	case "advanced", "canonical", "transport":
+	default:
+		fatalf("unknown output encoding: %s", *outFlag)
+	}
+
+	if *prettyFlag && *outFlag != "advanced" {
+		fatalf("-p requires advanced output")
+	}
+
+	if *selectorFlag != "" {
+		sel, err := sx.SX.Parse([]byte(*selectorFlag))
+		if err != nil {
+			fatalf("bad selector: %v", err)
+		}
+		for _, s := range sel {
+			if _, ok := s.(string); !ok {
+				fatalf("bad selector: non-string element")
+			}
+		}
+	}
+
+	ok := true
+	if flag.NArg() == 0 {
+		ok = process("<stdin>", os.Stdin)
+	}
+
+	for _, name := range flag.Args() {
+		f, err := os.Open(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "sx: %v\n", err)
+			ok = false
+			continue
+		}
+
+		if !process(name, f) {
+			ok = false
+		}
+		f.Close()
+	}
+
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+func process(name string, r io.Reader) bool {
+	in, err := ioutil.ReadAll(r)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sx: %s: %v\n", name, err)
+		return false
+	}
+
+	vs, err := parse(in)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sx: %s:%v\n", name, err)
+		return false
+	}
+
+	if *checkFlag {
+		return true
+	}
+
+	if *selectorFlag != "" {
+		vs = sx.Q1bsyt(vs, *selectorFlag)
+		if vs == nil {
+			fmt.Fprintf(os.Stderr, "sx: %s: selector matched nothing\n", name)
+			return false
+		}
+	}
+
+	err = output(vs, os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sx: %s: %v\n", name, err)
+		return false
+	}
+
+	return true
+}
+
+type positionError struct {
+	line, col int
+	err       error
+}
+
+func (e *positionError) Error() string {
+	return fmt.Sprintf("%d:%d: %v", e.line, e.col, e.err)
+}
+
+func parse(in []byte) ([]interface{}, error) {
+	p := sx.SX.NewParser()
+	_, err := p.Write(in)
+	if err == nil {
+		err = p.Close()
+	}
+
+	if err != nil {
+		off := p.Offset()
+		if e, ok := err.(*sx.EOFError); ok {
+			off = e.Offset
+		}
+		line, col := position(in, off)
+		return nil, &positionError{line, col, err}
+	}
+
+	return p.Tokens(), nil
+}
+
+// Converts a byte offset to a 1-based line and column.
+func position(in []byte, off int64) (int, int) {
+	if off > int64(len(in)) {
+		off = int64(len(in))
+	}
+
+	before := in[:off]
+	line := bytes.Count(before, []byte{'\n'}) + 1
+	col := len(before) - bytes.LastIndexByte(before, '\n')
+	return line, col
+}
+
+func output(vs []interface{}, w io.Writer) error {
+	switch *outFlag {
+	case "canonical":
+		return sx.SXCanonical.Write(vs, w)
+	case "transport":
+		s, err := sx.SXCanonical.String(vs)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "{%s}\n", base64.StdEncoding.EncodeToString([]byte(s)))
+		return err
+	default:
+		if *prettyFlag {
+			return sx.SX.WriteIndent(vs, w)
+		}
+		err := sx.SX.Write(vs, w)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(w, "\n")
+		return err
+	}
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "sx: "+format+"\n", args...)
+	os.Exit(2)
+}
@@ -0,0 +1,233 @@
+// Command sxfmt formats S-expression source files.
+//
+// Usage:
+//
+//   sxfmt [flags] [file...]
+//
+// Without flags, the formatted source is written to stdout. Input is read from
+// the named files, or from stdin if none are given. Comments and the style in
+// which each atom is written are preserved. Flags:
+//
+//   -l   list files whose formatting differs from sxfmt's
+//   -w   write the result to the source file instead of stdout
+//   -d   display diffs instead of rewriting files
+//
+// See sx.FormatSource for a description of the layout.
+package main
+
+import "bytes"
+import "flag"
+import "fmt"
+import "io"
+import "io/ioutil"
+import "os"
+import "strings"
+import "github.com/hlandau/sx"
+
+var listFlag = flag.Bool("l", false, "list files whose formatting differs from sxfmt's")
+var writeFlag = flag.Bool("w", false, "write result to the source file instead of stdout")
+var diffFlag = flag.Bool("d", false, "display diffs instead of rewriting files")
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: sxfmt [flags] [file...]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		if *writeFlag {
+			fatalf("cannot use -w with standard input")
+		}
+		if !process("<stdin>", os.Stdin, false) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	ok := true
+	for _, name := range flag.Args() {
+		f, err := os.Open(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "sxfmt: %v\n", err)
+			ok = false
+			continue
+		}
+
+		if !process(name, f, true) {
+			ok = false
+		}
+		f.Close()
+	}
+
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+func process(name string, r io.Reader, isFile bool) bool {
+	in, err := ioutil.ReadAll(r)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sxfmt: %s: %v\n", name, err)
+		return false
+	}
+
+	out, err := sx.FormatSource(in)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sxfmt: %s: %v\n", name, err)
+		return false
+	}
+
+	changed := !bytes.Equal(in, out)
+	if *listFlag && changed {
+		fmt.Println(name)
+	}
+
+	if *writeFlag && isFile && changed {
+		fi, err := os.Stat(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "sxfmt: %v\n", err)
+			return false
+		}
+		err = ioutil.WriteFile(name, out, fi.Mode().Perm())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "sxfmt: %v\n", err)
+			return false
+		}
+	}
+
+	if *diffFlag && changed {
+		os.Stdout.WriteString(diff(name, in, out))
+	}
+
+	if !*listFlag && !*writeFlag && !*diffFlag {
+		os.Stdout.Write(out)
+	}
+
+	return true
+}
+
+// Produces a unified diff of a and b, with three lines of context.
+func diff(name string, a, b []byte) string {
+	x := splitLines(a)
+	y := splitLines(b)
+
+	// Longest common subsequence, computed from the end.
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	// Edit script: ' ', '-' or '+' followed by the line.
+	type edit struct {
+		op   byte
+		line string
+	}
+	var es []edit
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			es = append(es, edit{' ', x[i]})
+			i++
+			j++
+		case i < len(x) && (j == len(y) || lcs[i+1][j] >= lcs[i][j+1]):
+			es = append(es, edit{'-', x[i]})
+			i++
+		default:
+			es = append(es, edit{'+', y[j]})
+			j++
+		}
+	}
+
+	const context = 3
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s.orig\n+++ %s\n", name, name)
+
+	for k := 0; k < len(es); {
+		if es[k].op == ' ' {
+			k++
+			continue
+		}
+
+		// Extend the hunk while changes are within 2*context lines of each
+		// other.
+		start := k - context
+		if start < 0 {
+			start = 0
+		}
+		end := k
+		for end < len(es) {
+			if es[end].op != ' ' {
+				end++
+				continue
+			}
+			n := end
+			for n < len(es) && es[n].op == ' ' {
+				n++
+			}
+			if n == len(es) || n-end > 2*context {
+				break
+			}
+			end = n
+		}
+		stop := end + context
+		if stop > len(es) {
+			stop = len(es)
+		}
+
+		// Line numbers at the start of the hunk.
+		ai, bi := 1, 1
+		for _, e := range es[:start] {
+			if e.op != '+' {
+				ai++
+			}
+			if e.op != '-' {
+				bi++
+			}
+		}
+		an, bn := 0, 0
+		for _, e := range es[start:stop] {
+			if e.op != '+' {
+				an++
+			}
+			if e.op != '-' {
+				bn++
+			}
+		}
+
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", ai, an, bi, bn)
+		for _, e := range es[start:stop] {
+			sb.WriteByte(e.op)
+			sb.WriteString(e.line)
+			sb.WriteByte('\n')
+		}
+		k = stop
+	}
+
+	return sb.String()
+}
+
+func splitLines(b []byte) []string {
+	s := strings.TrimSuffix(string(b), "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "sxfmt: "+format+"\n", args...)
+	os.Exit(2)
+}
@@ -0,0 +1,217 @@
+package sx
+
+import "fmt"
+import "strings"
+
+// Structural diff.
+//
+// Diff compares two documents and produces an edit script which, when
+// applied to the first by Patch, yields the second. Edits identify values by
+// Path and are applied in order, each path referring to the document as it
+// stands after the previous edits.
+//
+// Children of a list are aligned so as to keep as many values unchanged as
+// possible. Elements with the same head yarn, e.g. (port 80) and (port 81),
+// are treated as the same element and compared recursively, so that a change
+// deep within a configuration is reported where it occurs rather than as a
+// replacement of the whole tree:
+//
+//   ~ /0/2/1 81
+//   + /0/3 (tls (cert "a.pem"))
+//   - /1 (old)
+
+type EditOp int
+
+const (
+	EditInsert  EditOp = iota // insert Value before Path
+	EditDelete                // delete the value at Path (Value is the old value)
+	EditReplace               // replace the value at Path with Value
+)
+
+// A single change to a document.
+type Edit struct {
+	Op    EditOp
+	Path  Path
+	Value interface{}
+}
+
+var ErrBadPath = fmt.Errorf("path does not exist in document")
+
+// Returns the edit in printable form, e.g. "+ /0/3 (port 81)".
+func (e Edit) String() string {
+	op := "?"
+	switch e.Op {
+	case EditInsert:
+		op = "+"
+	case EditDelete:
+		op = "-"
+	case EditReplace:
+		op = "~"
+	}
+
+	s, err := SX.String([]interface{}{e.Value})
+	if err != nil {
+		s = fmt.Sprintf("%v", e.Value)
+	}
+	return op + " " + e.Path.String() + " " + s
+}
+
+// Formats an edit script, one edit per line.
+func EditsString(edits []Edit) string {
+	var b strings.Builder
+	for _, e := range edits {
+		b.WriteString(e.String())
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// Computes an edit script transforming a into b.
+func Diff(a, b []interface{}) []Edit {
+	var edits []Edit
+	diffList(nil, a, b, &edits)
+	return edits
+}
+
+// Scores how well two values correspond: 4 if equal, 2 if elements with the
+// same head yarn, 1 if they may be compared recursively or replaced, and 0 if
+// they should not be aligned.
+func diffScore(x, y interface{}) int {
+	if Equal(x, y) {
+		return 4
+	}
+
+	xs, xok := x.([]interface{})
+	ys, yok := y.([]interface{})
+	if xok != yok {
+		return 0
+	}
+	if !xok {
+		return 1
+	}
+
+	hx, hxok := headYarn(xs)
+	hy, hyok := headYarn(ys)
+	switch {
+	case hxok && hyok && hx == hy:
+		return 2
+	case !hxok && !hyok:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func diffList(path Path, a, b []interface{}, edits *[]Edit) {
+	// Alignment maximizing the total score, computed from the end.
+	score := make([][]int, len(a)+1)
+	for i := range score {
+		score[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			s := score[i+1][j]
+			if score[i][j+1] > s {
+				s = score[i][j+1]
+			}
+			if d := diffScore(a[i], b[j]); d > 0 && score[i+1][j+1]+d > s {
+				s = score[i+1][j+1] + d
+			}
+			score[i][j] = s
+		}
+	}
+
+	// n is the index in the list as edited so far.
+	i, j, n := 0, 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && diffScore(a[i], b[j]) > 0 && score[i][j] == score[i+1][j+1]+diffScore(a[i], b[j]):
+			diffValue(path.append(n), a[i], b[j], edits)
+			i++
+			j++
+			n++
+		case i < len(a) && (j == len(b) || score[i][j] == score[i+1][j]):
+			*edits = append(*edits, Edit{EditDelete, path.append(n), a[i]})
+			i++
+		default:
+			*edits = append(*edits, Edit{EditInsert, path.append(n), b[j]})
+			j++
+			n++
+		}
+	}
+}
+
+func diffValue(path Path, x, y interface{}, edits *[]Edit) {
+	if Equal(x, y) {
+		return
+	}
+
+	xs, xok := x.([]interface{})
+	ys, yok := y.([]interface{})
+	if xok && yok {
+		diffList(path, xs, ys, edits)
+		return
+	}
+
+	*edits = append(*edits, Edit{EditReplace, path, y})
+}
+
+// Applies an edit script to a document, returning the edited document. The
+// original document is not modified.
+func Patch(doc []interface{}, edits []Edit) ([]interface{}, error) {
+	for _, e := range edits {
+		var err error
+		doc, err = patchList(doc, e, e.Path)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", e, err)
+		}
+	}
+	return doc, nil
+}
+
+// Applies an edit at the relative path p within xs, copying xs and any lists
+// along the path.
+func patchList(xs []interface{}, e Edit, p Path) ([]interface{}, error) {
+	if len(p) == 0 {
+		return nil, ErrBadPath
+	}
+
+	i := p[0]
+	limit := len(xs)
+	if len(p) == 1 && e.Op == EditInsert {
+		limit++
+	}
+	if i < 0 || i >= limit {
+		return nil, ErrBadPath
+	}
+
+	ys := make([]interface{}, len(xs), len(xs)+1)
+	copy(ys, xs)
+
+	if len(p) > 1 {
+		child, ok := ys[i].([]interface{})
+		if !ok {
+			return nil, ErrBadPath
+		}
+		child, err := patchList(child, e, p[1:])
+		if err != nil {
+			return nil, err
+		}
+		ys[i] = child
+		return ys, nil
+	}
+
+	switch e.Op {
+	case EditInsert:
+		ys = append(ys, nil)
+		copy(ys[i+1:], ys[i:])
+		ys[i] = e.Value
+	case EditDelete:
+		ys = append(ys[:i], ys[i+1:]...)
+	case EditReplace:
+		ys[i] = e.Value
+	default:
+		return nil, fmt.Errorf("unknown edit operation: %d", e.Op)
+	}
+	return ys, nil
+}
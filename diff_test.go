@@ -0,0 +1,76 @@
+package sx_test
+
+import "testing"
+import "github.com/hlandau/sx"
+
+type diffCase struct {
+	A, B  string
+	Edits string
+}
+
+var diffCases = []diffCase{
+	{`(a)`, `(a)`, ``},
+	{`(server (name "a") (port 80))`, `(server (name "a") (port 81))`, "~ /0/2/1 81\n"},
+	{`(server (port 80))`, `(server (port 80) (tls (cert "a.pem")))`, "+ /0/2 (tls (cert a.pem))\n"},
+	{`(a) (old) (b)`, `(a) (b)`, "- /1 (old)\n"},
+	{`(x 1 2 3)`, `(x 1 3 4)`, "- /0/2 2\n+ /0/3 4\n"},
+	{`(s (name a)) (s (name b))`, `(s (name b))`, "- /0 (s (name a))\n"},
+	{`(a (b (c 1)))`, `(a (b (c 2) (d)))`, "~ /0/1/1/1 2\n+ /0/1/2 (d)\n"},
+	{`(a 1)`, `(b 1)`, "- /0 (a 1)\n+ /0 (b 1)\n"},
+	{`(a)`, `x`, "- /0 (a)\n+ /0 x\n"},
+}
+
+func TestDiff(t *testing.T) {
+	for _, c := range diffCases {
+		a, err := sx.SX.Parse([]byte(c.A))
+		if err != nil {
+			t.Fatalf("failed to parse: %v", err)
+		}
+		b, err := sx.SX.Parse([]byte(c.B))
+		if err != nil {
+			t.Fatalf("failed to parse: %v", err)
+		}
+
+		edits := sx.Diff(a, b)
+		if s := sx.EditsString(edits); s != c.Edits {
+			t.Errorf("unexpected diff: %s -> %s:\n%s", c.A, c.B, s)
+		}
+
+		out, err := sx.Patch(a, edits)
+		if err != nil {
+			t.Errorf("cannot patch: %s -> %s: %v", c.A, c.B, err)
+			continue
+		}
+		if !sx.Equal(out, b) {
+			t.Errorf("patch mismatch: %s -> %s: %v", c.A, c.B, out)
+		}
+
+		orig, _ := sx.SX.Parse([]byte(c.A))
+		if !sx.Equal(a, orig) {
+			t.Errorf("patch modified its input: %s", c.A)
+		}
+	}
+}
+
+func TestPatchBadPath(t *testing.T) {
+	doc, err := sx.SX.Parse([]byte(`(a b) c`))
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+
+	for _, e := range []sx.Edit{
+		{sx.EditDelete, nil, nil},
+		{sx.EditDelete, sx.Path{2}, nil},
+		{sx.EditReplace, sx.Path{1, 0}, "x"},
+		{sx.EditInsert, sx.Path{0, 3}, "x"},
+	} {
+		if _, err := sx.Patch(doc, []sx.Edit{e}); err == nil {
+			t.Errorf("expected error: %v", e)
+		}
+	}
+
+	out, err := sx.Patch(doc, []sx.Edit{{sx.EditInsert, sx.Path{0, 2}, "x"}})
+	if err != nil || !sx.Equal(out, []interface{}{[]interface{}{"a", "b", "x"}, "c"}) {
+		t.Errorf("unexpected result: %v %v", out, err)
+	}
+}
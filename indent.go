@@ -0,0 +1,83 @@
+package sx
+
+import "bufio"
+import "fmt"
+import "io"
+
+// Width beyond which WriteIndent breaks lists across lines.
+const indentWidth = 80
+
+var ErrIndentCanonical = fmt.Errorf("cannot indent canonical form")
+
+// Writes the slice as an indented, human-readable S-expression string to the
+// io.Writer. Each value is written on its own line. A list which does not fit
+// on one line is broken so that its leading atoms stay on the first line and
+// each following value goes on a line of its own, indented by two spaces:
+//
+//   (certificate
+//     (issuer (name (public-key rsa-with-md5 (e |NFGq/E3wh9f4rJIQVXhS|))))
+//     (not-before "1997-01-01_09:00:00"))
+//
+// Canonical formats cannot be indented.
+func (f *Format) WriteIndent(vs []interface{}, w io.Writer) error {
+	if f.serializationMode == szModeCanonical {
+		return ErrIndentCanonical
+	}
+
+	b := bufio.NewWriter(w)
+	for _, v := range vs {
+		v, err := Marshal(v)
+		if err != nil {
+			return err
+		}
+		if err := writeIndent(v, b, f, 0); err != nil {
+			return err
+		}
+		b.WriteByte('\n')
+	}
+	return b.Flush()
+}
+
+func writeIndent(v interface{}, b *bufio.Writer, f *Format, depth int) error {
+	one, err := f.String([]interface{}{v})
+	if err != nil {
+		return err
+	}
+
+	xs, ok := v.([]interface{})
+	if !ok || len(xs) == 0 || depth*2+len(one) <= indentWidth {
+		b.WriteString(one)
+		return nil
+	}
+
+	b.WriteByte('(')
+	i := 0
+	for ; i < len(xs); i++ {
+		if _, ok := xs[i].([]interface{}); ok {
+			break
+		}
+		s, err := f.String(xs[i : i+1])
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(s)
+	}
+
+	for ; i < len(xs); i++ {
+		if i > 0 {
+			b.WriteByte('\n')
+			for j := 0; j <= depth; j++ {
+				b.WriteString("  ")
+			}
+		}
+		if err := writeIndent(xs[i], b, f, depth+1); err != nil {
+			return err
+		}
+	}
+
+	b.WriteByte(')')
+	return nil
+}
@@ -0,0 +1,316 @@
+package sx
+
+import "bytes"
+import "encoding/base64"
+import "encoding/json"
+import "fmt"
+import "io"
+import "strconv"
+import "strings"
+import "unicode/utf8"
+
+// JSON conversion.
+//
+// JSON values are mapped to S-expressions as follows:
+//
+//   "text"           "text"
+//   42               42 (int, int64 or uint64, as the parser would produce)
+//   1.5, 1e100       ("@number" "1.5"), ("@number" "1e100")
+//   true/false/null  ("@true"), ("@false"), ("@null")
+//   [a, b]           (a b)
+//   {"k": v, ...}    ("@object" (k v) ...), or ("@object" k v ...) as a plist
+//   {"@base64": s}   the decoded bytes of s
+//
+// and S-expressions are mapped back to JSON by the reverse of the above.
+// Strings which are not valid UTF-8 are mapped to {"@base64": ...}.
+//
+// Lists whose head is a string beginning with '@' are reserved for the forms
+// above. A JSON array whose first element is a string beginning with '@' is
+// mapped to a list whose head has an extra '@' prepended, and the extra '@'
+// is removed again when mapping back, so ["@x", 1] becomes ("@@x" 1).
+//
+// Thus converting a JSON value to an S-expression and back yields the same
+// JSON value, and converting an S-expression to JSON and back yields the same
+// S-expression. The exceptions are {"@base64": ...} objects which decode to
+// valid UTF-8, which become JSON strings, and -0 in JSON, which becomes 0.
+// Other numbers, such as 1.0, are kept as written in ("@number" ...).
+
+type JSONObjectForm int
+
+const (
+	JSONAlist JSONObjectForm = iota // ("@object" (k v) ...)
+	JSONPlist                       // ("@object" k v ...)
+)
+
+// Converts a JSON document to an S-expression value. Objects are represented
+// in the given form.
+func FromJSON(data []byte, form JSONObjectForm) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	v, err := fromJSON(dec, form)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("json: trailing data after value")
+	}
+
+	return v, nil
+}
+
+func fromJSON(dec *json.Decoder, form JSONObjectForm) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch t := tok.(type) {
+	case string:
+		return t, nil
+	case json.Number:
+		return fromJSONNumber(string(t)), nil
+	case bool:
+		if t {
+			return []interface{}{"@true"}, nil
+		}
+		return []interface{}{"@false"}, nil
+	case nil:
+		return []interface{}{"@null"}, nil
+	case json.Delim:
+		if t == '[' {
+			xs := []interface{}{}
+			for dec.More() {
+				x, err := fromJSON(dec, form)
+				if err != nil {
+					return nil, err
+				}
+				if s, ok := x.(string); ok && len(xs) == 0 && strings.HasPrefix(s, "@") {
+					x = "@" + s
+				}
+				xs = append(xs, x)
+			}
+			_, err := dec.Token()
+			return xs, err
+		}
+
+		xs := []interface{}{"@object"}
+		for dec.More() {
+			k, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			v, err := fromJSON(dec, form)
+			if err != nil {
+				return nil, err
+			}
+			if form == JSONPlist {
+				xs = append(xs, k, v)
+			} else {
+				xs = append(xs, []interface{}{k, v})
+			}
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+
+		if b, ok := base64Object(xs); ok {
+			return b, nil
+		}
+		return xs, nil
+	default:
+		return nil, fmt.Errorf("json: unexpected token: %v", tok)
+	}
+}
+
+// Recognises {"@base64": "..."} in either object form.
+func base64Object(xs []interface{}) (string, bool) {
+	kv := xs[1:]
+	if len(kv) == 1 {
+		pair, ok := kv[0].([]interface{})
+		if !ok {
+			return "", false
+		}
+		kv = pair
+	}
+
+	if len(kv) != 2 || kv[0] != "@base64" {
+		return "", false
+	}
+
+	s, ok := kv[1].(string)
+	if !ok {
+		return "", false
+	}
+
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", false
+	}
+
+	return string(b), true
+}
+
+func fromJSONNumber(s string) interface{} {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if n >= -0x80000000 && n <= 0x7FFFFFFF {
+			return int(n)
+		}
+		return n
+	}
+
+	if n, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return n
+	}
+
+	return []interface{}{"@number", s}
+}
+
+// Converts an S-expression value to a JSON document.
+func ToJSON(v interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	err := toJSON(v, &b)
+	if err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func toJSON(v interface{}, b *bytes.Buffer) error {
+	switch vv := v.(type) {
+	case int:
+		b.WriteString(strconv.FormatInt(int64(vv), 10))
+	case int64:
+		b.WriteString(strconv.FormatInt(vv, 10))
+	case uint64:
+		b.WriteString(strconv.FormatUint(vv, 10))
+	case string:
+		return jsonString(vv, b)
+	case []byte:
+		return jsonString(string(vv), b)
+	case []interface{}:
+		return jsonList(vv, b)
+	default:
+		mv, err := Marshal(v)
+		if err != nil {
+			return err
+		}
+		return toJSON(mv, b)
+	}
+	return nil
+}
+
+func jsonString(s string, b *bytes.Buffer) error {
+	if !utf8.ValidString(s) {
+		b.WriteString(`{"@base64":"`)
+		b.WriteString(base64.StdEncoding.EncodeToString([]byte(s)))
+		b.WriteString(`"}`)
+		return nil
+	}
+
+	enc := json.NewEncoder(b)
+	enc.SetEscapeHTML(false)
+	err := enc.Encode(s)
+	if err != nil {
+		return err
+	}
+
+	// Encode appends a newline.
+	b.Truncate(b.Len() - 1)
+	return nil
+}
+
+func jsonList(xs []interface{}, b *bytes.Buffer) error {
+	head, _ := atomString(firstOf(xs))
+	switch {
+	case head == "@object":
+		return jsonObject(xs[1:], b)
+	case head == "@true" || head == "@false" || head == "@null":
+		if len(xs) != 1 {
+			return fmt.Errorf("json: malformed %s", head)
+		}
+		b.WriteString(head[1:])
+		return nil
+	case head == "@number":
+		if len(xs) != 2 {
+			return fmt.Errorf("json: malformed @number")
+		}
+		s, ok := atomString(xs[1])
+		if !ok {
+			return fmt.Errorf("json: malformed @number")
+		}
+		var n json.Number
+		if err := json.Unmarshal([]byte(s), &n); err != nil {
+			return fmt.Errorf("json: malformed @number: %q", s)
+		}
+		b.WriteString(s)
+		return nil
+	case strings.HasPrefix(head, "@@"):
+		xs = append([]interface{}{head[1:]}, xs[1:]...)
+	case strings.HasPrefix(head, "@"):
+		return fmt.Errorf("json: unknown reserved form: %s", head)
+	}
+
+	b.WriteByte('[')
+	for i, x := range xs {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		if err := toJSON(x, b); err != nil {
+			return err
+		}
+	}
+	b.WriteByte(']')
+	return nil
+}
+
+func firstOf(xs []interface{}) interface{} {
+	if len(xs) == 0 {
+		return nil
+	}
+	return xs[0]
+}
+
+// Writes an object from either an alist or a plist.
+func jsonObject(kv []interface{}, b *bytes.Buffer) error {
+	alist := true
+	for _, x := range kv {
+		if xs, ok := x.([]interface{}); !ok || len(xs) != 2 {
+			alist = false
+			break
+		}
+	}
+
+	if !alist {
+		if len(kv)%2 != 0 {
+			return fmt.Errorf("json: malformed @object")
+		}
+		pairs := make([]interface{}, 0, len(kv)/2)
+		for i := 0; i < len(kv); i += 2 {
+			pairs = append(pairs, []interface{}{kv[i], kv[i+1]})
+		}
+		kv = pairs
+	}
+
+	b.WriteByte('{')
+	for i, x := range kv {
+		pair := x.([]interface{})
+		k, ok := atomString(pair[0])
+		if !ok || !utf8.ValidString(k) {
+			return fmt.Errorf("json: object keys must be UTF-8 strings")
+		}
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		if err := jsonString(k, b); err != nil {
+			return err
+		}
+		b.WriteByte(':')
+		if err := toJSON(pair[1], b); err != nil {
+			return err
+		}
+	}
+	b.WriteByte('}')
+	return nil
+}
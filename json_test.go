@@ -0,0 +1,61 @@
+package sx_test
+
+import "testing"
+import "github.com/hlandau/sx"
+
+var jsonCases = []struct {
+	JSON, SX string
+}{
+	{`"hello"`, `hello`},
+	{`42`, `42`},
+	{`-9999999999`, `-9999999999`},
+	{`18446744073709551615`, `18446744073709551615`},
+	{`1.5`, `("@number" "1.5")`},
+	{`true`, `("@true")`},
+	{`null`, `("@null")`},
+	{`[1,"a",[]]`, `(1 a ())`},
+	{`["@x",1]`, `("@@x" 1)`},
+	{`{"b":1,"a":[true,false],"b":2}`, `("@object" (b 1) (a (("@true") ("@false"))) (b 2))`},
+	{`{"@base64":"/wA="}`, `#ff00#`},
+	{`{"k":"<&>"}`, `("@object" (k "<&>"))`},
+}
+
+func TestJSON(t *testing.T) {
+	for _, c := range jsonCases {
+		v, err := sx.FromJSON([]byte(c.JSON), sx.JSONAlist)
+		if err != nil {
+			t.Errorf("cannot convert from JSON: %s: %v", c.JSON, err)
+			continue
+		}
+
+		if !sx.Equal(v, parseOne(t, c.SX)) {
+			t.Errorf("unexpected conversion: %s: %#v", c.JSON, v)
+		}
+
+		j, err := sx.ToJSON(parseOne(t, c.SX))
+		if err != nil {
+			t.Errorf("cannot convert to JSON: %s: %v", c.SX, err)
+			continue
+		}
+
+		if string(j) != c.JSON {
+			t.Errorf("unexpected conversion: %s: %s", c.SX, j)
+		}
+	}
+
+	v, err := sx.FromJSON([]byte(`{"a":1,"b":[2]}`), sx.JSONPlist)
+	if err != nil || !sx.Equal(v, parseOne(t, `("@object" a 1 b (2))`)) {
+		t.Errorf("unexpected plist conversion: %v, %v", v, err)
+	}
+
+	j, err := sx.ToJSON(v)
+	if err != nil || string(j) != `{"a":1,"b":[2]}` {
+		t.Errorf("unexpected plist conversion: %s, %v", j, err)
+	}
+
+	for _, s := range []string{`("@unknown")`, `("@true" 1)`, `("@number" "abc")`, `("@object" a)`} {
+		if _, err := sx.ToJSON(parseOne(t, s)); err == nil {
+			t.Errorf("expected error: %s", s)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package sx
+
+import "crypto"
+import "crypto/ecdsa"
+import "crypto/ed25519"
+import "crypto/elliptic"
+import "crypto/rsa"
+import "fmt"
+import "math/big"
+
+// Keys are represented using the libgcrypt S-expression forms, as also used
+// by GnuPG's private-keys-v1.d, for example:
+//
+//   (public-key (rsa (n #00...#) (e #010001#)))
+//   (private-key (rsa (n ...) (e ...) (d ...) (p ...) (q ...) (u ...)))
+//   (public-key (ecc (curve Ed25519) (flags eddsa) (q |QNd1...|)))
+//   (private-key (ecc (curve "NIST P-256") (q #04...#) (d ...)))
+//
+// Integers are big-endian byte strings, which may be written using any of the
+// hex, base64 or verbatim string syntaxes.
+
+var ErrUnsupportedKey = fmt.Errorf("unsupported key type")
+
+var curveNames = map[string]elliptic.Curve{
+	"NIST P-256": elliptic.P256(),
+	"NIST P-384": elliptic.P384(),
+	"NIST P-521": elliptic.P521(),
+	"nistp256":   elliptic.P256(),
+	"nistp384":   elliptic.P384(),
+	"nistp521":   elliptic.P521(),
+}
+
+func curveName(c elliptic.Curve) (string, bool) {
+	switch c {
+	case elliptic.P256():
+		return "NIST P-256", true
+	case elliptic.P384():
+		return "NIST P-384", true
+	case elliptic.P521():
+		return "NIST P-521", true
+	default:
+		return "", false
+	}
+}
+
+// Converts an *rsa.PublicKey, ed25519.PublicKey or *ecdsa.PublicKey to a
+// (public-key ...) S-expression.
+func PublicKeyToSX(pub crypto.PublicKey) ([]interface{}, error) {
+	var alg []interface{}
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		alg = rsaParams(k)
+	case ed25519.PublicKey:
+		alg = ed25519Params(k)
+	case *ecdsa.PublicKey:
+		var err error
+		alg, err = ecdsaParams(k)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrUnsupportedKey
+	}
+
+	return []interface{}{"public-key", alg}, nil
+}
+
+// Converts an *rsa.PrivateKey, ed25519.PrivateKey or *ecdsa.PrivateKey to a
+// (private-key ...) S-expression.
+func PrivateKeyToSX(priv crypto.PrivateKey) ([]interface{}, error) {
+	var alg []interface{}
+	switch k := priv.(type) {
+	case *rsa.PrivateKey:
+		if len(k.Primes) != 2 {
+			return nil, fmt.Errorf("multi-prime RSA keys are not supported")
+		}
+
+		// libgcrypt requires p < q and u = p^-1 mod q.
+		p, q := k.Primes[0], k.Primes[1]
+		if p.Cmp(q) > 0 {
+			p, q = q, p
+		}
+		u := new(big.Int).ModInverse(p, q)
+
+		alg = append(rsaParams(&k.PublicKey),
+			[]interface{}{"d", mpi(k.D)},
+			[]interface{}{"p", mpi(p)},
+			[]interface{}{"q", mpi(q)},
+			[]interface{}{"u", mpi(u)})
+	case ed25519.PrivateKey:
+		alg = append(ed25519Params(k.Public().(ed25519.PublicKey)),
+			[]interface{}{"d", string(k.Seed())})
+	case *ecdsa.PrivateKey:
+		var err error
+		alg, err = ecdsaParams(&k.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		alg = append(alg, []interface{}{"d", mpi(k.D)})
+	default:
+		return nil, ErrUnsupportedKey
+	}
+
+	return []interface{}{"private-key", alg}, nil
+}
+
+func rsaParams(k *rsa.PublicKey) []interface{} {
+	return []interface{}{"rsa",
+		[]interface{}{"n", mpi(k.N)},
+		[]interface{}{"e", mpi(big.NewInt(int64(k.E)))},
+	}
+}
+
+func ed25519Params(k ed25519.PublicKey) []interface{} {
+	return []interface{}{"ecc",
+		[]interface{}{"curve", "Ed25519"},
+		[]interface{}{"flags", "eddsa"},
+		[]interface{}{"q", "\x40" + string(k)},
+	}
+}
+
+func ecdsaParams(k *ecdsa.PublicKey) ([]interface{}, error) {
+	name, ok := curveName(k.Curve)
+	if !ok {
+		return nil, ErrUnsupportedKey
+	}
+
+	return []interface{}{"ecc",
+		[]interface{}{"curve", name},
+		[]interface{}{"q", string(elliptic.Marshal(k.Curve, k.X, k.Y))},
+	}, nil
+}
+
+// Returns the algorithm name and parameters of a (head (alg ...)) key.
+func keyAlg(v interface{}, head string) (string, []interface{}, error) {
+	if !Hhy(v, head) {
+		return "", nil, fmt.Errorf("not a %s", head)
+	}
+
+	xs := v.([]interface{})
+	if len(xs) != 2 {
+		return "", nil, fmt.Errorf("malformed %s", head)
+	}
+
+	alg, ok := xs[1].([]interface{})
+	if !ok || len(alg) == 0 {
+		return "", nil, fmt.Errorf("malformed %s", head)
+	}
+
+	name, ok := atomString(alg[0])
+	if !ok {
+		return "", nil, fmt.Errorf("malformed %s", head)
+	}
+
+	return name, alg[1:], nil
+}
+
+// Converts a (public-key ...) S-expression to an *rsa.PublicKey,
+// ed25519.PublicKey or *ecdsa.PublicKey.
+func PublicKeyFromSX(v interface{}) (crypto.PublicKey, error) {
+	alg, params, err := keyAlg(v, "public-key")
+	if err != nil {
+		return nil, err
+	}
+
+	switch alg {
+	case "rsa":
+		return rsaPublicKey(params)
+	case "ecc":
+		return eccPublicKey(params)
+	default:
+		return nil, ErrUnsupportedKey
+	}
+}
+
+// Converts a (private-key ...) S-expression to an *rsa.PrivateKey,
+// ed25519.PrivateKey or *ecdsa.PrivateKey.
+func PrivateKeyFromSX(v interface{}) (crypto.PrivateKey, error) {
+	alg, params, err := keyAlg(v, "private-key")
+	if err != nil {
+		return nil, err
+	}
+
+	switch alg {
+	case "rsa":
+		return rsaPrivateKey(params)
+	case "ecc":
+		return eccPrivateKey(params)
+	default:
+		return nil, ErrUnsupportedKey
+	}
+}
+
+func rsaPublicKey(params []interface{}) (*rsa.PublicKey, error) {
+	n, ok := keyParamInt(params, "n")
+	if !ok {
+		return nil, fmt.Errorf("rsa key: missing n")
+	}
+
+	e, ok := keyParamInt(params, "e")
+	if !ok {
+		return nil, fmt.Errorf("rsa key: missing e")
+	}
+
+	if !e.IsInt64() || e.Int64() > 1<<31-1 || e.Int64() < 3 {
+		return nil, fmt.Errorf("rsa key: unsupported exponent")
+	}
+
+	return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+}
+
+func rsaPrivateKey(params []interface{}) (*rsa.PrivateKey, error) {
+	pub, err := rsaPublicKey(params)
+	if err != nil {
+		return nil, err
+	}
+
+	k := &rsa.PrivateKey{PublicKey: *pub}
+	for _, name := range []string{"d", "p", "q"} {
+		x, ok := keyParamInt(params, name)
+		if !ok {
+			return nil, fmt.Errorf("rsa key: missing %s", name)
+		}
+
+		if name == "d" {
+			k.D = x
+		} else {
+			k.Primes = append(k.Primes, x)
+		}
+	}
+
+	err = k.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	k.Precompute()
+	return k, nil
+}
+
+func eccPrivateKey(params []interface{}) (crypto.PrivateKey, error) {
+	pub, err := eccPublicKey(params)
+	if err != nil {
+		return nil, err
+	}
+
+	d, ok := keyParamString(params, "d")
+	if !ok {
+		return nil, fmt.Errorf("ecc key: missing d")
+	}
+
+	switch k := pub.(type) {
+	case ed25519.PublicKey:
+		if len(d) != ed25519.SeedSize {
+			return nil, fmt.Errorf("ecc key: invalid Ed25519 seed")
+		}
+		priv := ed25519.NewKeyFromSeed([]byte(d))
+		if !k.Equal(priv.Public()) {
+			return nil, fmt.Errorf("ecc key: q does not match d")
+		}
+		return priv, nil
+	case *ecdsa.PublicKey:
+		priv := &ecdsa.PrivateKey{PublicKey: *k, D: new(big.Int).SetBytes([]byte(d))}
+		x, y := k.Curve.ScalarBaseMult(priv.D.Bytes())
+		if x.Cmp(k.X) != 0 || y.Cmp(k.Y) != 0 {
+			return nil, fmt.Errorf("ecc key: q does not match d")
+		}
+		return priv, nil
+	default:
+		return nil, ErrUnsupportedKey
+	}
+}
+
+func eccPublicKey(params []interface{}) (crypto.PublicKey, error) {
+	curve, ok := keyParamString(params, "curve")
+	if !ok {
+		return nil, fmt.Errorf("ecc key: missing curve")
+	}
+
+	q, ok := keyParamString(params, "q")
+	if !ok {
+		return nil, fmt.Errorf("ecc key: missing q")
+	}
+
+	if curve == "Ed25519" {
+		return ed25519Point(q)
+	}
+
+	c, ok := curveNames[curve]
+	if !ok {
+		return nil, ErrUnsupportedKey
+	}
+
+	x, y := elliptic.Unmarshal(c, []byte(q))
+	if x == nil {
+		return nil, fmt.Errorf("ecc key: invalid point")
+	}
+
+	return &ecdsa.PublicKey{Curve: c, X: x, Y: y}, nil
+}
+
+// Ed25519 points are stored by GnuPG with a 0x40 prefix byte, which is
+// optional.
+func ed25519Point(q string) (ed25519.PublicKey, error) {
+	if len(q) == ed25519.PublicKeySize+1 && q[0] == 0x40 {
+		q = q[1:]
+	}
+	if len(q) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("ecc key: invalid Ed25519 point")
+	}
+	return ed25519.PublicKey(q), nil
+}
+
+// Returns the single string argument of the (name ...) parameter.
+func keyParamString(params []interface{}, name string) (string, bool) {
+	v := Q1bhyt(params, name)
+	if len(v) != 1 {
+		return "", false
+	}
+	return atomString(v[0])
+}
+
+// Returns the (name ...) parameter as an integer. Integers are encoded as
+// big-endian byte strings in the libgcrypt manner.
+func keyParamInt(params []interface{}, name string) (*big.Int, bool) {
+	s, ok := keyParamString(params, name)
+	if !ok {
+		return nil, false
+	}
+	return new(big.Int).SetBytes([]byte(s)), true
+}
+
+// Encodes a nonnegative integer as a big-endian byte string. A leading zero
+// byte is added if the high bit would otherwise be set, as libgcrypt does.
+func mpi(x *big.Int) string {
+	b := x.Bytes()
+	if len(b) == 0 || b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	return string(b)
+}
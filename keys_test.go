@@ -0,0 +1,88 @@
+package sx_test
+
+import "crypto"
+import "crypto/ecdsa"
+import "crypto/ed25519"
+import "crypto/elliptic"
+import "crypto/rand"
+import "crypto/rsa"
+import "testing"
+import "github.com/hlandau/sx"
+
+func TestKeys(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("cannot generate key: %v", err)
+	}
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("cannot generate key: %v", err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("cannot generate key: %v", err)
+	}
+
+	type equaler interface {
+		Equal(crypto.PrivateKey) bool
+	}
+
+	for _, k := range []crypto.Signer{rsaKey, edKey, ecKey} {
+		v, err := sx.PrivateKeyToSX(k)
+		if err != nil {
+			t.Fatalf("cannot convert private key: %v", err)
+		}
+
+		s, err := sx.SX.String([]interface{}{v})
+		if err != nil {
+			t.Fatalf("cannot serialize private key: %v", err)
+		}
+
+		k2, err := sx.PrivateKeyFromSX(parseOne(t, s))
+		if err != nil {
+			t.Fatalf("cannot parse private key: %v: %s", err, s)
+		}
+
+		// libgcrypt orders RSA primes such that p < q, which may differ from the
+		// order of the original key.
+		if rk, ok := k2.(*rsa.PrivateKey); ok {
+			if rk.D.Cmp(rsaKey.D) != 0 || !rk.PublicKey.Equal(&rsaKey.PublicKey) {
+				t.Fatalf("private key mismatch")
+			}
+		} else if !k2.(equaler).Equal(k) {
+			t.Fatalf("private key mismatch")
+		}
+
+		v, err = sx.PublicKeyToSX(k.Public())
+		if err != nil {
+			t.Fatalf("cannot convert public key: %v", err)
+		}
+
+		pub, err := sx.PublicKeyFromSX(v)
+		if err != nil {
+			t.Fatalf("cannot parse public key: %v", err)
+		}
+
+		if !pub.(interface{ Equal(crypto.PublicKey) bool }).Equal(k.Public()) {
+			t.Fatalf("public key mismatch")
+		}
+	}
+}
+
+func TestGcryptPublicKey(t *testing.T) {
+	pub, err := sx.PublicKeyFromSX(parseOne(t, `
+    (public-key
+      (rsa
+        (n #00c3a1d0e2f5b7a9c8e1f2a3b4c5d6e7f8091a2b3c4d5e6f708192a3b4c5d6e7f9#)
+        (e #010001#)))`))
+	if err != nil {
+		t.Fatalf("cannot parse public key: %v", err)
+	}
+
+	k, ok := pub.(*rsa.PublicKey)
+	if !ok || k.E != 65537 || k.N.BitLen() != 256 {
+		t.Fatalf("unexpected key: %#v", pub)
+	}
+}
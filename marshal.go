@@ -0,0 +1,154 @@
+package sx
+
+import "encoding"
+import "fmt"
+import "math"
+
+// Implemented by types which can represent themselves as a value, for
+// example
+//
+//   func (id UserID) MarshalSX() (interface{}, error) {
+//     return []interface{}{"user", int64(id)}, nil
+//   }
+//
+// The value returned may itself contain Marshalers. Types which implement
+// encoding.TextMarshaler but not Marshaler are represented as strings.
+type Marshaler interface {
+	MarshalSX() (interface{}, error)
+}
+
+// Implemented by types which can set themselves from a value. The value must
+// not be retained after UnmarshalSX returns, since it may alias parser input.
+type Unmarshaler interface {
+	UnmarshalSX(v interface{}) error
+}
+
+var ErrUnmarshalType = fmt.Errorf("value cannot be unmarshaled into destination type")
+
+// Converts v to a value consisting only of the types produced by the parser,
+// calling Marshalers and encoding.TextMarshalers as necessary. Lists are
+// copied. Returns ErrUnsupportedType if v or a value within it is of another
+// type.
+//
+// Marshal is applied automatically by Format.Write and ToJSON.
+func Marshal(v interface{}) (interface{}, error) {
+	switch vv := v.(type) {
+	case string, []byte, int, int64, uint64:
+		return v, nil
+	case []interface{}:
+		out := make([]interface{}, len(vv))
+		for i, x := range vv {
+			y, err := Marshal(x)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = y
+		}
+		return out, nil
+	case Marshaler:
+		x, err := vv.MarshalSX()
+		if err != nil {
+			return nil, err
+		}
+		return Marshal(x)
+	case encoding.TextMarshaler:
+		b, err := vv.MarshalText()
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	default:
+		return nil, ErrUnsupportedType
+	}
+}
+
+// Stores the value v in the variable pointed to by dst. dst must implement
+// Unmarshaler or encoding.TextUnmarshaler, or be one of
+//
+//   *string, *[]byte          v must be a string or []byte
+//   *int, *int64, *uint64     v must be an integer within range
+//   *[]interface{}            v must be a list; it is stored as is
+//   *interface{}              v is stored as is
+//
+// Strings and byte slices are copied. Lists are not: the values stored for
+// *[]interface{} and *interface{} share v's lists, and so, if v was parsed
+// with ZeroCopy, may hold []byte atoms which alias the parser input.
+//
+// Returns an error wrapping ErrUnmarshalType if v is of the wrong type for
+// dst, and ErrUnsupportedType if dst is of some other type.
+func Unmarshal(v interface{}, dst interface{}) error {
+	switch d := dst.(type) {
+	case Unmarshaler:
+		return d.UnmarshalSX(v)
+	case encoding.TextUnmarshaler:
+		s, ok := atomString(v)
+		if !ok {
+			break
+		}
+		return d.UnmarshalText([]byte(s))
+	case *string:
+		s, ok := atomString(v)
+		if !ok {
+			break
+		}
+		*d = s
+		return nil
+	case *[]byte:
+		s, ok := atomString(v)
+		if !ok {
+			break
+		}
+		*d = []byte(s)
+		return nil
+	case *int:
+		x, ok := unmarshalInt(v)
+		if !ok || x < math.MinInt || x > math.MaxInt {
+			break
+		}
+		*d = int(x)
+		return nil
+	case *int64:
+		x, ok := unmarshalInt(v)
+		if !ok {
+			break
+		}
+		*d = x
+		return nil
+	case *uint64:
+		mag, neg, ok := atomInt(v)
+		if !ok || neg {
+			break
+		}
+		*d = mag
+		return nil
+	case *[]interface{}:
+		xs, ok := v.([]interface{})
+		if !ok {
+			break
+		}
+		*d = xs
+		return nil
+	case *interface{}:
+		*d = v
+		return nil
+	default:
+		return ErrUnsupportedType
+	}
+
+	return fmt.Errorf("%w: %T into %T", ErrUnmarshalType, v, dst)
+}
+
+// Returns an integer value as an int64 if it is within range.
+func unmarshalInt(v interface{}) (int64, bool) {
+	mag, neg, ok := atomInt(v)
+	switch {
+	case !ok:
+		return 0, false
+	case neg && mag <= 1<<63:
+		return -int64(mag), true
+	case !neg && mag <= math.MaxInt64:
+		return int64(mag), true
+	default:
+		return 0, false
+	}
+}
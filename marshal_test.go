@@ -0,0 +1,126 @@
+package sx_test
+
+import "bytes"
+import "errors"
+import "fmt"
+import "net"
+import "testing"
+import "github.com/hlandau/sx"
+
+type userID int64
+
+func (id userID) MarshalSX() (interface{}, error) {
+	return []interface{}{"user", int64(id)}, nil
+}
+
+func (id *userID) UnmarshalSX(v interface{}) error {
+	xs, ok := v.([]interface{})
+	if !ok || len(xs) != 2 || !sx.Hhy(v, "user") {
+		return fmt.Errorf("malformed user ID")
+	}
+	var x int64
+	if err := sx.Unmarshal(xs[1], &x); err != nil {
+		return err
+	}
+	*id = userID(x)
+	return nil
+}
+
+type badValue struct{}
+
+func (badValue) MarshalSX() (interface{}, error) {
+	return nil, fmt.Errorf("cannot marshal")
+}
+
+func TestMarshal(t *testing.T) {
+	doc := []interface{}{
+		[]interface{}{"grant", userID(42), net.ParseIP("192.0.2.1"), "x"},
+		userID(7),
+	}
+
+	s, err := sx.SX.String(doc)
+	if err != nil {
+		t.Fatalf("cannot write: %v", err)
+	}
+	plain, _ := sx.SX.Parse([]byte(`(grant (user 42) "192.0.2.1" x) (user 7)`))
+	if expected, _ := sx.SX.String(plain); s != expected {
+		t.Errorf("unexpected output: %s", s)
+	}
+
+	var b bytes.Buffer
+	if err := sx.SX.WriteIndent(doc[1:], &b); err != nil || b.String() != "(user 7)\n" {
+		t.Errorf("unexpected indented output: %q %v", b.String(), err)
+	}
+
+	j, err := sx.ToJSON(doc[0])
+	if err != nil || string(j) != `["grant",["user",42],"192.0.2.1","x"]` {
+		t.Errorf("unexpected JSON: %s %v", j, err)
+	}
+
+	// A Marshaler within a quote prefix form is spaced as the value it returns.
+	f := sx.SX
+	f.ReadQuotePrefixes = true
+	f.WriteQuotePrefixes = true
+	s, err = f.String([]interface{}{[]interface{}{[]interface{}{"quote", userID(7)}, "g"}})
+	if err != nil || s != "('(user 7)g)" {
+		t.Fatalf("unexpected output: %s %v", s, err)
+	}
+	xs, err := f.Parse([]byte(s))
+	if err != nil || !sx.Equal(xs, []interface{}{parseOne(t, `((quote (user 7)) g)`)}) {
+		t.Errorf("unexpected round trip: %s -> %v %v", s, xs, err)
+	}
+
+	if _, err := sx.SX.String([]interface{}{"a", badValue{}}); err == nil {
+		t.Errorf("expected marshal error")
+	}
+	if _, err := sx.SX.String([]interface{}{1.5}); !errors.Is(err, sx.ErrUnsupportedType) {
+		t.Errorf("expected unsupported type: %v", err)
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	vs, err := sx.SX.Parse([]byte(`(user 42) "192.0.2.1" abc -5 18446744073709551615 (a b)`))
+	if err != nil {
+		t.Fatalf("cannot parse: %v", err)
+	}
+
+	var id userID
+	var ip net.IP
+	var s string
+	var n int
+	var u uint64
+	var l []interface{}
+	for i, dst := range []interface{}{&id, &ip, &s, &n, &u, &l} {
+		if err := sx.Unmarshal(vs[i], dst); err != nil {
+			t.Fatalf("cannot unmarshal %v: %v", vs[i], err)
+		}
+	}
+	if id != 42 || !ip.Equal(net.ParseIP("192.0.2.1")) || s != "abc" || n != -5 ||
+		u != 18446744073709551615 || !sx.Equal(l, []interface{}{"a", "b"}) {
+		t.Errorf("unexpected values: %v %v %v %v %v %v", id, ip, s, n, u, l)
+	}
+
+	var x int64
+	for _, c := range []struct {
+		V   interface{}
+		Dst interface{}
+	}{
+		{"abc", &x},
+		{-1, &u},
+		{uint64(1 << 63), &x},
+		{[]interface{}{}, &s},
+		{5, &ip},
+	} {
+		if err := sx.Unmarshal(c.V, c.Dst); !errors.Is(err, sx.ErrUnmarshalType) {
+			t.Errorf("expected type error for %v into %T: %v", c.V, c.Dst, err)
+		}
+	}
+
+	var f float64
+	if err := sx.Unmarshal(1, &f); !errors.Is(err, sx.ErrUnsupportedType) {
+		t.Errorf("expected unsupported type: %v", err)
+	}
+	if err := sx.Unmarshal("x", &id); err == nil {
+		t.Errorf("expected unmarshaler error")
+	}
+}
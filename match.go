@@ -0,0 +1,244 @@
+package sx
+
+import "fmt"
+import "strings"
+import "sync"
+
+// Variable bindings resulting from a successful pattern match.
+type Bindings map[string]interface{}
+
+// Structural pattern.
+//
+// A pattern is an S-expression in which atoms beginning with '?' are
+// variables. All other values must match exactly (see Equal). Variables take
+// the following forms:
+//
+//   ?           matches any single value
+//   ?name       matches any single value and binds it to name
+//   ?name:type  as above, but the value must be of the given type
+//   ?name...    matches the remaining values of the enclosing list and binds
+//               them to name as a list; only valid as the last element
+//   ?...        matches the remaining values of the enclosing list
+//
+// The types are int, string, atom (int or string) and list. A rest variable
+// may also have a type, in which case every value it matches must be of that
+// type. If the same name is used more than once, every occurrence must match
+// equal values.
+//
+// For example, the pattern
+//
+//   (server ?name (port ?p:int) ?rest...)
+//
+// matches (server alpha (port 80) (tls) (log debug)), binding name to
+// "alpha", p to 80 and rest to ((tls) (log debug)).
+type Pattern struct {
+	v interface{}
+}
+
+type patternVar struct {
+	name string // "" for anonymous
+	typ  string // "" for any
+	rest bool
+}
+
+// Parses a pattern from its textual form, which must contain exactly one
+// value.
+func ParsePattern(s string) (*Pattern, error) {
+	f := SX
+	f.allowPatternVariables = true
+
+	vs, err := f.Parse([]byte(s))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(vs) != 1 {
+		return nil, fmt.Errorf("pattern must contain exactly one value")
+	}
+
+	err = checkPattern(vs[0], false)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Pattern{v: vs[0]}, nil
+}
+
+func checkPattern(v interface{}, last bool) error {
+	if xs, ok := v.([]interface{}); ok {
+		for i, x := range xs {
+			if err := checkPattern(x, i == len(xs)-1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	pv, ok, err := parsePatternVar(v)
+	if err != nil {
+		return err
+	}
+
+	if ok && pv.rest && !last {
+		return fmt.Errorf("rest variable must be the last element of a list")
+	}
+
+	return nil
+}
+
+func parsePatternVar(v interface{}) (patternVar, bool, error) {
+	var pv patternVar
+
+	s, ok := atomString(v)
+	if !ok || !strings.HasPrefix(s, "?") {
+		return pv, false, nil
+	}
+
+	s = s[1:]
+	if strings.HasSuffix(s, "...") {
+		pv.rest = true
+		s = s[:len(s)-3]
+	}
+
+	if idx := strings.IndexByte(s, ':'); idx >= 0 {
+		pv.typ = s[idx+1:]
+		s = s[:idx]
+		switch pv.typ {
+		case "int", "string", "atom", "list":
+		default:
+			return pv, false, fmt.Errorf("unknown pattern variable type: %s", pv.typ)
+		}
+	}
+
+	pv.name = s
+	return pv, true, nil
+}
+
+// Matches a value against the pattern. Returns the bindings and true if the
+// value matches.
+func (p *Pattern) Match(v interface{}) (Bindings, bool) {
+	b := Bindings{}
+	if !matchValue(p.v, v, b) {
+		return nil, false
+	}
+	return b, true
+}
+
+// Parses a pattern as ParsePattern does, but panics if the pattern is
+// malformed. It is intended for patterns which are constants, for example
+//
+//   var serverPattern = sx.MustParsePattern(`(server ?name ?rest...)`)
+//
+func MustParsePattern(s string) *Pattern {
+	p, err := ParsePattern(s)
+	if err != nil {
+		panic(fmt.Sprintf("bad pattern: %v", err))
+	}
+	return p
+}
+
+// Parsed patterns used by Match. The cache is emptied when it reaches
+// maxCachedPatterns entries, so that programs which construct pattern text
+// dynamically do not use unbounded memory.
+var patternCache = map[string]*Pattern{}
+var patternCacheMutex sync.Mutex
+
+const maxCachedPatterns = 256
+
+// Matches a value against a pattern given in textual form. Recently used
+// patterns are cached, so calling Match repeatedly with the same constant
+// pattern is cheap.
+//
+// Like MustParsePattern, Match panics if the pattern is malformed, so it is
+// intended for patterns which are constants. Patterns constructed at run time
+// should be parsed with ParsePattern, which returns an error, and matched with
+// (*Pattern).Match.
+func Match(pattern string, v interface{}) (Bindings, bool) {
+	patternCacheMutex.Lock()
+	p, ok := patternCache[pattern]
+	patternCacheMutex.Unlock()
+
+	if !ok {
+		p = MustParsePattern(pattern)
+
+		patternCacheMutex.Lock()
+		if len(patternCache) >= maxCachedPatterns {
+			patternCache = map[string]*Pattern{}
+		}
+		patternCache[pattern] = p
+		patternCacheMutex.Unlock()
+	}
+
+	return p.Match(v)
+}
+
+func matchValue(pat, v interface{}, b Bindings) bool {
+	if pxs, ok := pat.([]interface{}); ok {
+		xs, ok := v.([]interface{})
+		if !ok {
+			return false
+		}
+
+		for i, px := range pxs {
+			pv, isVar, _ := parsePatternVar(px)
+			if isVar && pv.rest {
+				rest := xs[i:]
+				for _, x := range rest {
+					if !matchType(pv.typ, x) {
+						return false
+					}
+				}
+				return bind(b, pv.name, append([]interface{}{}, rest...))
+			}
+
+			if i >= len(xs) || !matchValue(px, xs[i], b) {
+				return false
+			}
+		}
+
+		return len(xs) == len(pxs)
+	}
+
+	pv, isVar, _ := parsePatternVar(pat)
+	if !isVar {
+		return Equal(pat, v)
+	}
+
+	if !matchType(pv.typ, v) {
+		return false
+	}
+
+	return bind(b, pv.name, v)
+}
+
+func matchType(typ string, v interface{}) bool {
+	_, isList := v.([]interface{})
+	_, isString := atomString(v)
+	_, _, isInt := atomInt(v)
+
+	switch typ {
+	case "int":
+		return isInt
+	case "string":
+		return isString
+	case "atom":
+		return isInt || isString
+	case "list":
+		return isList
+	default:
+		return true
+	}
+}
+
+func bind(b Bindings, name string, v interface{}) bool {
+	if name == "" {
+		return true
+	}
+
+	if old, ok := b[name]; ok {
+		return Equal(old, v)
+	}
+
+	b[name] = v
+	return true
+}
@@ -0,0 +1,80 @@
+package sx_test
+
+import "testing"
+import "github.com/hlandau/sx"
+
+type matchCase struct {
+	Pattern, In string
+	Bindings    string // (name value) pairs, or "-" for no match
+}
+
+var matchCases = []matchCase{
+	{"(server ?name (port ?p:int) ?rest...)", "(server alpha (port 80) (tls) (log debug))",
+		"(name alpha) (p 80) (rest ((tls) (log debug)))"},
+	{"(server ?name (port ?p:int) ?rest...)", "(server alpha (port eighty))", "-"},
+	{"(server ?name (port ?p:int) ?rest...)", "(server alpha (port 80))", "(name alpha) (p 80) (rest ())"},
+	{"(pair ?x ?x)", "(pair 1 1)", "(x 1)"},
+	{"(pair ?x ?x)", "(pair 1 2)", "-"},
+	{"(a ? ?...)", "(a (b c) d e)", ""},
+	{"(a ?:list ?y:atom)", "(a (b c) d)", "(y d)"},
+	{"(a ?:list)", "(a b)", "-"},
+	{"(a b)", "(a b c)", "-"},
+	{"(a ?xs:int...)", "(a 1 2 3)", "(xs (1 2 3))"},
+	{"(a ?xs:int...)", "(a 1 two 3)", "-"},
+}
+
+func TestMatch(t *testing.T) {
+	for _, c := range matchCases {
+		b, ok := sx.Match(c.Pattern, parseOne(t, c.In))
+		if c.Bindings == "-" {
+			if ok {
+				t.Errorf("unexpected match: %s, %s: %v", c.Pattern, c.In, b)
+			}
+			continue
+		}
+
+		if !ok {
+			t.Errorf("expected match: %s, %s", c.Pattern, c.In)
+			continue
+		}
+
+		expected, err := sx.SX.Parse([]byte(c.Bindings))
+		if err != nil {
+			t.Fatalf("bad test case: %v", err)
+		}
+
+		if len(expected) != len(b) {
+			t.Errorf("binding mismatch: %s, %s: %v", c.Pattern, c.In, b)
+			continue
+		}
+
+		for _, e := range expected {
+			kv := e.([]interface{})
+			if !sx.Equal(b[kv[0].(string)], kv[1]) {
+				t.Errorf("binding mismatch: %s, %s: %v", c.Pattern, c.In, b)
+			}
+		}
+	}
+}
+
+func TestBadPattern(t *testing.T) {
+	for _, s := range []string{"(a ?r... b)", "(a ?x:float)", "a b"} {
+		if _, err := sx.ParsePattern(s); err == nil {
+			t.Errorf("expected error: %s", s)
+		}
+	}
+
+	for _, fn := range []func(){
+		func() { sx.MustParsePattern("(a ?x:float)") },
+		func() { sx.Match("(a ?x:float)", "a") },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected panic for malformed pattern")
+				}
+			}()
+			fn()
+		}()
+	}
+}
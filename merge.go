@@ -0,0 +1,267 @@
+package sx
+
+import "fmt"
+
+// Merging of layered configuration.
+//
+// Merge overlays one document on another. Documents are assumed to follow the
+// head yarn convention used by Q1bhy, so that the elements of an overlay are
+// matched against elements of the base which have the same head yarn. For
+// example, with the default deep-merge strategy,
+//
+//   base:    (server (name "a") (port 80) (log (level info)))
+//   overlay: (server (port 8080) (log (file "x.log")))
+//   result:  (server (name "a") (port 8080) (log (level info) (file "x.log")))
+//
+// How an overlay element is combined with the base elements having the same
+// head is determined by the strategy for that head yarn:
+//
+//   MergeDeep     the Nth overlay element is merged with the Nth base element
+//                 recursively; the arguments (atoms) of an element are
+//                 replaced if the overlay element has any; extra overlay
+//                 elements are added at the end
+//   MergeReplace  the base elements are replaced by the overlay elements
+//   MergeAppend   the overlay elements are added after the base elements
+//
+// An overlay may remove elements of the base with a deletion marker:
+//
+//   (-delete log)      removes all (log ...) elements at this level
+//
+// Elements which are new in the overlay are added after the existing ones,
+// and otherwise the order of the base is preserved.
+
+type MergeStrategy int
+
+const (
+	MergeDeep MergeStrategy = iota
+	MergeReplace
+	MergeAppend
+)
+
+// Head yarn of deletion markers in an overlay.
+const MergeDeleteMarker = "-delete"
+
+type MergeOptions struct {
+	// Strategy used for heads not listed in Strategies.
+	Default MergeStrategy
+
+	// Strategy by head yarn, applying at any depth.
+	Strategies map[string]MergeStrategy
+}
+
+func (o *MergeOptions) strategy(head string) MergeStrategy {
+	if o == nil {
+		return MergeDeep
+	}
+	if s, ok := o.Strategies[head]; ok {
+		return s
+	}
+	return o.Default
+}
+
+// Records which layer each value in a merged document came from, keyed by
+// Path.String(). Layers are numbered from 0 (the base). An element merged
+// from several layers is attributed to the last of them; its children have
+// entries of their own.
+type Provenance map[string]int
+
+// Returns the layer from which the value at the path came, or -1 if there is
+// no such value.
+func (p Provenance) Layer(path Path) int {
+	if l, ok := p[path.String()]; ok {
+		return l
+	}
+	return -1
+}
+
+// Merges overlay onto base. This is the same as MergeLayers with two layers.
+func Merge(base, overlay []interface{}, opts *MergeOptions) ([]interface{}, Provenance, error) {
+	return MergeLayers([][]interface{}{base, overlay}, opts)
+}
+
+// Merges each layer onto the result of merging the previous layers, starting
+// with layers[0]. Deletion markers are removed from the result.
+func MergeLayers(layers [][]interface{}, opts *MergeOptions) ([]interface{}, Provenance, error) {
+	var cur []*mergeNode
+	for i, l := range layers {
+		var err error
+		cur, err = mergeBody(cur, mergeNodes(l, i), opts)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	prov := Provenance{}
+	out := make([]interface{}, len(cur))
+	for i, n := range cur {
+		out[i] = n.value(Path{i}, prov)
+	}
+	return out, prov, nil
+}
+
+// A value annotated with the layer it came from.
+type mergeNode struct {
+	atom   interface{}
+	list   []*mergeNode
+	isList bool
+	layer  int
+}
+
+func mergeNodes(xs []interface{}, layer int) []*mergeNode {
+	ns := make([]*mergeNode, len(xs))
+	for i, x := range xs {
+		n := &mergeNode{atom: x, layer: layer}
+		if l, ok := x.([]interface{}); ok {
+			n.atom, n.list, n.isList = nil, mergeNodes(l, layer), true
+		}
+		ns[i] = n
+	}
+	return ns
+}
+
+func (n *mergeNode) value(path Path, prov Provenance) interface{} {
+	prov[path.String()] = n.layer
+	if !n.isList {
+		return n.atom
+	}
+
+	xs := make([]interface{}, len(n.list))
+	for i, c := range n.list {
+		xs[i] = c.value(path.append(i), prov)
+	}
+	return xs
+}
+
+// Returns the head yarn of n if it is an element.
+func (n *mergeNode) head() (string, bool) {
+	if !n.isList || len(n.list) == 0 || n.list[0].isList {
+		return "", false
+	}
+	return atomString(n.list[0].atom)
+}
+
+func mergeBody(base, over []*mergeNode, opts *MergeOptions) ([]*mergeNode, error) {
+	out := make([]*mergeNode, 0, len(base)+len(over))
+
+	// Deletions.
+	deleted := map[string]bool{}
+	for _, o := range over {
+		if h, ok := o.head(); ok && h == MergeDeleteMarker {
+			for _, x := range o.list[1:] {
+				name, ok := atomString(x.atom)
+				if x.isList || !ok {
+					return nil, fmt.Errorf("merge: malformed deletion marker")
+				}
+				deleted[name] = true
+			}
+		}
+	}
+
+	// Arguments in the overlay replace those of the base.
+	var overArgs []*mergeNode
+	for _, o := range over {
+		if _, ok := o.head(); !ok {
+			overArgs = append(overArgs, o)
+		}
+	}
+
+	out = append(out, overArgs...)
+	for _, b := range base {
+		h, ok := b.head()
+		if (!ok && len(overArgs) > 0) || (ok && deleted[h]) {
+			continue
+		}
+		out = append(out, b)
+	}
+
+	// Elements, grouped by head in order of first appearance.
+	var heads []string
+	byHead := map[string][]*mergeNode{}
+	for _, o := range over {
+		h, ok := o.head()
+		if !ok || h == MergeDeleteMarker {
+			continue
+		}
+		if _, seen := byHead[h]; !seen {
+			heads = append(heads, h)
+		}
+		byHead[h] = append(byHead[h], o)
+	}
+
+	for _, h := range heads {
+		ovs := byHead[h]
+
+		// Overlay elements as they appear when not merged with the base, i.e.
+		// with any nested deletion markers removed.
+		fresh := make([]*mergeNode, len(ovs))
+		for i, o := range ovs {
+			body, err := mergeBody(nil, o.list[1:], opts)
+			if err != nil {
+				return nil, err
+			}
+			fresh[i] = &mergeNode{
+				list:   append([]*mergeNode{o.list[0]}, body...),
+				isList: true,
+				layer:  o.layer,
+			}
+		}
+
+		var idx []int
+		for i, n := range out {
+			if nh, ok := n.head(); ok && nh == h {
+				idx = append(idx, i)
+			}
+		}
+
+		switch opts.strategy(h) {
+		case MergeDeep:
+			for k, o := range ovs {
+				if k >= len(idx) {
+					out = append(out, fresh[k])
+					continue
+				}
+				b := out[idx[k]]
+				body, err := mergeBody(b.list[1:], o.list[1:], opts)
+				if err != nil {
+					return nil, err
+				}
+				out[idx[k]] = &mergeNode{
+					list:   append([]*mergeNode{o.list[0]}, body...),
+					isList: true,
+					layer:  o.layer,
+				}
+			}
+		case MergeReplace:
+			at := len(out)
+			if len(idx) > 0 {
+				at = idx[0]
+			}
+			rest := make([]*mergeNode, 0, len(out)+len(fresh))
+			for i, n := range out {
+				if i == at {
+					rest = append(rest, fresh...)
+				}
+				if nh, ok := n.head(); !ok || nh != h {
+					rest = append(rest, n)
+				}
+			}
+			if at == len(out) {
+				rest = append(rest, fresh...)
+			}
+			out = rest
+		case MergeAppend:
+			at := len(out)
+			if len(idx) > 0 {
+				at = idx[len(idx)-1] + 1
+			}
+			rest := make([]*mergeNode, 0, len(out)+len(fresh))
+			rest = append(rest, out[:at]...)
+			rest = append(rest, fresh...)
+			out = append(rest, out[at:]...)
+		default:
+			return nil, fmt.Errorf("merge: unknown strategy: %d", opts.strategy(h))
+		}
+	}
+
+	return out, nil
+}
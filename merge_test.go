@@ -0,0 +1,92 @@
+package sx_test
+
+import "testing"
+import "github.com/hlandau/sx"
+
+type mergeCase struct {
+	Base, Overlay, Out string
+}
+
+var mergeCases = []mergeCase{
+	{
+		`(server (name "a") (port 80) (log (level info)))`,
+		`(server (port 8080) (log (file "x.log")))`,
+		`(server (name "a") (port 8080) (log (level info) (file "x.log")))`,
+	},
+	{`(a 1 2 (b))`, `(a 3)`, `(a 3 (b))`},
+	{`(a (b) (c))`, `(a (-delete b) (d))`, `(a (c) (d))`},
+	{`(a (listen 1) (listen 2) (x))`, `(a (listen 3))`, `(a (listen 3) (listen 2) (x))`},
+	{`(a (route 1) (route 2) (x))`, `(a (route 3) (route 4))`, `(a (route 3) (route 4) (x))`},
+	{`(a (acl 1) (x))`, `(a (acl 2))`, `(a (acl 1) (acl 2) (x))`},
+	{`(a)`, `(b (c (-delete d)))`, `(a) (b (c))`},
+}
+
+func TestMerge(t *testing.T) {
+	opts := &sx.MergeOptions{
+		Strategies: map[string]sx.MergeStrategy{
+			"route": sx.MergeReplace,
+			"acl":   sx.MergeAppend,
+		},
+	}
+
+	for _, c := range mergeCases {
+		base, err := sx.SX.Parse([]byte(c.Base))
+		if err != nil {
+			t.Fatalf("failed to parse: %v", err)
+		}
+		overlay, err := sx.SX.Parse([]byte(c.Overlay))
+		if err != nil {
+			t.Fatalf("failed to parse: %v", err)
+		}
+		expected, err := sx.SX.Parse([]byte(c.Out))
+		if err != nil {
+			t.Fatalf("failed to parse: %v", err)
+		}
+
+		out, _, err := sx.Merge(base, overlay, opts)
+		if err != nil {
+			t.Errorf("cannot merge: %s + %s: %v", c.Base, c.Overlay, err)
+			continue
+		}
+		if !sx.Equal(out, expected) {
+			s, _ := sx.SX.String(out)
+			t.Errorf("unexpected merge: %s + %s: %s", c.Base, c.Overlay, s)
+		}
+	}
+}
+
+func TestMergeProvenance(t *testing.T) {
+	layers := [][]interface{}{
+		[]interface{}{parseOne(t, `(server (name "a") (port 80))`)},
+		[]interface{}{parseOne(t, `(server (port 8080))`)},
+		[]interface{}{parseOne(t, `(server (tls))`)},
+	}
+
+	out, prov, err := sx.MergeLayers(layers, nil)
+	if err != nil {
+		t.Fatalf("cannot merge: %v", err)
+	}
+	if !sx.Equal(out, []interface{}{parseOne(t, `(server (name "a") (port 8080) (tls))`)}) {
+		t.Fatalf("unexpected merge: %v", out)
+	}
+
+	for path, layer := range map[string]int{
+		"/0":     2,
+		"/0/1":   0,
+		"/0/2":   1,
+		"/0/2/1": 1,
+		"/0/3":   2,
+	} {
+		if prov[path] != layer {
+			t.Errorf("unexpected layer for %s: %d", path, prov[path])
+		}
+	}
+
+	if l := prov.Layer(sx.Path{0, 9}); l != -1 {
+		t.Errorf("unexpected layer for missing path: %d", l)
+	}
+
+	if _, _, err := sx.Merge(nil, []interface{}{parseOne(t, `(-delete (x))`)}, nil); err == nil {
+		t.Errorf("expected error for malformed deletion marker")
+	}
+}
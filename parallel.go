@@ -0,0 +1,98 @@
+package sx
+
+import "runtime"
+import "sync"
+
+// Inputs are split into chunks of at least this many bytes.
+const minParallelChunk = 16 << 10
+
+// Parse a S-expression string using multiple goroutines and return a slice of
+// the values parsed or an error. The result is the same as that of Parse.
+//
+// The input is split into chunks at boundaries between top-level values,
+// which are found by a quick scan that skips length-prefixed atoms without
+// examining them. The chunks are parsed by up to workers goroutines; if
+// workers is zero or negative, GOMAXPROCS is used. This is worthwhile for
+// large inputs consisting of many top-level values, such as logs.
+//
+// If a chunk fails to parse, the whole input is parsed again with Parse so
+// that the error reported is the same. The input is parsed with Parse also if
+// MaxTokens is set, since the limit applies to the input as a whole.
+func (fmt *Format) ParseParallel(b []byte, workers int) ([]interface{}, error) {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers == 1 || fmt.MaxTokens != 0 ||
+		(fmt.MaxInputBytes != 0 && uint64(len(b)) > fmt.MaxInputBytes) {
+		return fmt.Parse(b)
+	}
+
+	size := len(b) / (workers * 4)
+	if size < minParallelChunk {
+		size = minParallelChunk
+	}
+
+	chunks := splitTopLevel(b, fmt, size)
+	if len(chunks) == 1 {
+		return fmt.Parse(b)
+	}
+
+	results := make([][]interface{}, len(chunks))
+	errs := make([]error, len(chunks))
+	next := make(chan int)
+
+	var wg sync.WaitGroup
+	if workers > len(chunks) {
+		workers = len(chunks)
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results[i], errs[i] = fmt.Parse(chunks[i])
+			}
+		}()
+	}
+	for i := range chunks {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	n := 0
+	for i, err := range errs {
+		if err != nil {
+			return fmt.Parse(b)
+		}
+		n += len(results[i])
+	}
+
+	vs := make([]interface{}, 0, n)
+	for _, r := range results {
+		vs = append(vs, r...)
+	}
+	return vs, nil
+}
+
+// Splits b into chunks of roughly size bytes which parse to the same values
+// independently as together. Chunks begin with a top-level value which
+// follows whitespace or a closing parenthesis, so that the previous value
+// cannot continue into it. If the scan finds anything it does not understand,
+// the rest of the input is left as a single chunk.
+func splitTopLevel(b []byte, f *Format, size int) [][]byte {
+	src := &sliceSource{b: b}
+	s := scanner{src: src, f: f, checkSub: true}
+	var chunks [][]byte
+	last := 0
+	for s.skipSpace() == nil && src.i < len(b) {
+		if src.i-last >= size && (isSpace(b[src.i-1]) || b[src.i-1] == ')') {
+			chunks = append(chunks, b[last:src.i])
+			last = src.i
+		}
+		if s.skipValue(0) != nil {
+			break
+		}
+	}
+	return append(chunks, b[last:])
+}
@@ -0,0 +1,67 @@
+package sx_test
+
+import "bytes"
+import "fmt"
+import "testing"
+import "github.com/hlandau/sx"
+
+// Generates a log of n records using a variety of syntax.
+func parallelDocument(n int, sep string) []byte {
+	var b bytes.Buffer
+	for i := 0; i < n; i++ {
+		switch i % 5 {
+		case 0:
+			fmt.Fprintf(&b, "(5:entry(2:id%d:%d)(4:data11:)(;\"|#{}x y))", len(fmt.Sprint(i)), i)
+		case 1:
+			fmt.Fprintf(&b, "(entry (id %d) (msg \"a \\\"quoted\\\" (string\") |AAECAw==| #0a0b#)", i)
+		case 2:
+			fmt.Fprintf(&b, "{KDU6ZW50cnkoMjppZDE6eCkp}")
+		case 3:
+			fmt.Fprintf(&b, "; comment (\n%d entry-%d", i, i)
+		case 4:
+			fmt.Fprintf(&b, "(entry 3\"a(b\" -%d (()) ((x)))", i)
+		}
+		b.WriteString(sep)
+	}
+	return b.Bytes()
+}
+
+func TestParseParallel(t *testing.T) {
+	for _, sep := range []string{"\n", ""} {
+		doc := parallelDocument(20000, sep)
+		expected, err := sx.SX.Parse(doc)
+		if err != nil {
+			t.Fatalf("cannot parse: %v", err)
+		}
+
+		for _, workers := range []int{0, 1, 2, 3, 8} {
+			vs, err := sx.SX.ParseParallel(doc, workers)
+			if err != nil {
+				t.Fatalf("cannot parse in parallel: %v", err)
+			}
+			if !sx.Equal(vs, expected) {
+				t.Errorf("mismatch with %d workers", workers)
+			}
+		}
+	}
+
+	// Errors are the same as for Parse.
+	doc := parallelDocument(20000, "\n")
+	doc = append(doc[:len(doc)/2:len(doc)/2], append([]byte("(a ]"), doc[len(doc)/2:]...)...)
+	_, expected := sx.SX.Parse(doc)
+	_, err := sx.SX.ParseParallel(doc, 4)
+	if err == nil || expected == nil || err.Error() != expected.Error() {
+		t.Errorf("unexpected error: %v, expected %v", err, expected)
+	}
+}
+
+func BenchmarkParseParallel(b *testing.B) {
+	doc := parallelDocument(100000, "\n")
+	b.SetBytes(int64(len(doc)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := sx.SX.ParseParallel(doc, 0); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
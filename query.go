@@ -0,0 +1,295 @@
+package sx
+
+import "fmt"
+import "strconv"
+import "strings"
+
+// A path identifies a value within a document. Each element is an index into
+// the list at that level; the first indexes the top-level list of values.
+type Path []int
+
+func (p Path) String() string {
+	var b strings.Builder
+	for _, i := range p {
+		b.WriteByte('/')
+		b.WriteString(strconv.Itoa(i))
+	}
+	if b.Len() == 0 {
+		return "/"
+	}
+	return b.String()
+}
+
+func (p Path) append(i int) Path {
+	q := make(Path, len(p), len(p)+1)
+	copy(q, p)
+	return append(q, i)
+}
+
+// A value matched by Query, and where it was found.
+type QueryResult struct {
+	Path  Path
+	Value interface{}
+}
+
+// Query by path expression.
+//
+// Unlike Q1bsyt, which follows the first match at each step, Query returns
+// every match. A query is a sequence of whitespace-separated steps, each of
+// which selects among the children of the values selected by the previous
+// step (initially, the top-level values of xs):
+//
+//   name         elements with head yarn name, e.g. (name ...)
+//   *            all elements
+//   ..           makes the following step select among all descendants
+//                rather than just children
+//
+// A step may be followed by any number of predicates:
+//
+//   [N]          the Nth match (from 0); negative values count from the end
+//   [key]        matches having a (key ...) child
+//   [key=value]  matches having a (key value ...) child; value is a single
+//                atom such as 42, foo or "foo bar"
+//
+// For example, given
+//
+//   (server (name "a") (port 80))
+//   (server (name "b") (port 81))
+//
+// the query `server[name="b"] port` returns (port 81), and `.. port` returns
+// both port elements. Results are in document order.
+func Query(xs []interface{}, q string) ([]QueryResult, error) {
+	steps, err := parseQuery(q)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each context is a list whose children are searched; the document itself
+	// is treated as a list whose children start at index 0.
+	type context struct {
+		path Path
+		v    []interface{}
+		base int
+	}
+
+	ctxs := []context{{nil, xs, 0}}
+	var results []QueryResult
+	for si, st := range steps {
+		results = nil
+		seen := map[string]bool{}
+		for _, c := range ctxs {
+			var matches []QueryResult
+			collectQuery(c.path, c.v, c.base, st, &matches)
+			for _, p := range st.preds {
+				matches = p.filter(matches)
+			}
+			for _, m := range matches {
+				k := m.Path.String()
+				if !seen[k] {
+					seen[k] = true
+					results = append(results, m)
+				}
+			}
+		}
+
+		if si == len(steps)-1 {
+			break
+		}
+
+		ctxs = ctxs[:0]
+		for _, r := range results {
+			ctxs = append(ctxs, context{r.Path, r.Value.([]interface{}), 1})
+		}
+	}
+
+	return results, nil
+}
+
+type queryStep struct {
+	desc  bool
+	name  string // "*" for any
+	preds []queryPred
+}
+
+type queryPred struct {
+	index    int
+	isIndex  bool
+	key      string
+	value    interface{}
+	hasValue bool
+}
+
+func collectQuery(path Path, v []interface{}, base int, st queryStep, out *[]QueryResult) {
+	for i := base; i < len(v); i++ {
+		xs, ok := v[i].([]interface{})
+		if !ok {
+			continue
+		}
+
+		p := path.append(i)
+		head, ok := headYarn(xs)
+		if ok && (st.name == "*" || head == st.name) {
+			*out = append(*out, QueryResult{p, xs})
+		}
+
+		if st.desc {
+			collectQuery(p, xs, 1, st, out)
+		}
+	}
+}
+
+func (p queryPred) filter(rs []QueryResult) []QueryResult {
+	if p.isIndex {
+		i := p.index
+		if i < 0 {
+			i += len(rs)
+		}
+		if i < 0 || i >= len(rs) {
+			return nil
+		}
+		return rs[i : i+1]
+	}
+
+	var out []QueryResult
+	for _, r := range rs {
+		xs := r.Value.([]interface{})
+		for _, x := range xs[1:] {
+			if !Hhy(x, p.key) {
+				continue
+			}
+			args := x.([]interface{})[1:]
+			if !p.hasValue || (len(args) > 0 && Equal(args[0], p.value)) {
+				out = append(out, r)
+				break
+			}
+		}
+	}
+	return out
+}
+
+func parseQuery(q string) ([]queryStep, error) {
+	var steps []queryStep
+	desc := false
+	for _, tok := range splitQuery(q) {
+		if tok == ".." {
+			desc = true
+			continue
+		}
+
+		st := queryStep{desc: desc}
+		desc = false
+
+		idx := strings.IndexByte(tok, '[')
+		if idx < 0 {
+			idx = len(tok)
+		}
+		st.name = tok[:idx]
+		if st.name == "" {
+			return nil, fmt.Errorf("bad query: missing name: %q", tok)
+		}
+
+		rest := tok[idx:]
+		for rest != "" {
+			end := predEnd(rest)
+			if rest[0] != '[' || end < 0 {
+				return nil, fmt.Errorf("bad query: malformed predicate: %q", tok)
+			}
+
+			p, err := parseQueryPred(rest[1:end])
+			if err != nil {
+				return nil, err
+			}
+
+			st.preds = append(st.preds, p)
+			rest = rest[end+1:]
+		}
+
+		steps = append(steps, st)
+	}
+
+	if desc {
+		return nil, fmt.Errorf("bad query: trailing ..")
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("bad query: empty query")
+	}
+
+	return steps, nil
+}
+
+func parseQueryPred(s string) (queryPred, error) {
+	var p queryPred
+	if n, err := strconv.Atoi(s); err == nil {
+		p.index, p.isIndex = n, true
+		return p, nil
+	}
+
+	eq := strings.IndexByte(s, '=')
+	if eq < 0 {
+		p.key = s
+	} else {
+		vs, err := SX.Parse([]byte(s[eq+1:]))
+		if err != nil || len(vs) != 1 {
+			return p, fmt.Errorf("bad query: malformed predicate value: %q", s)
+		}
+		p.key, p.value, p.hasValue = s[:eq], vs[0], true
+	}
+
+	if p.key == "" {
+		return p, fmt.Errorf("bad query: malformed predicate: %q", s)
+	}
+	return p, nil
+}
+
+// Returns the index of the ']' which closes the predicate starting at s[0],
+// allowing for quoted strings.
+func predEnd(s string) int {
+	quoted := false
+	for i := 1; i < len(s); i++ {
+		switch {
+		case quoted && s[i] == '\\':
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case !quoted && s[i] == ']':
+			return i
+		}
+	}
+	return -1
+}
+
+// Splits a query into steps at whitespace which is not within a predicate.
+func splitQuery(q string) []string {
+	var toks []string
+	start := -1
+	depth := 0
+	quoted := false
+	for i := 0; i < len(q); i++ {
+		c := q[i]
+		switch {
+		case quoted && c == '\\':
+			i++
+			continue
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		case depth == 0 && (c == ' ' || c == '\t' || c == '\r' || c == '\n'):
+			if start >= 0 {
+				toks = append(toks, q[start:i])
+				start = -1
+			}
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		toks = append(toks, q[start:])
+	}
+	return toks
+}
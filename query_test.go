@@ -0,0 +1,68 @@
+package sx_test
+
+import "strings"
+import "testing"
+import "github.com/hlandau/sx"
+
+const queryDoc = `
+(server (name "a") (port 80)
+  (route (path "/") (backend (port 8080))))
+(server (name "b") (port 81))
+(client (port 9000))
+`
+
+type queryCase struct {
+	Query string
+	Paths string
+}
+
+var queryCases = []queryCase{
+	{"server", "/0 /1"},
+	{"server port", "/0/2 /1/2"},
+	{`server[name="b"] port`, "/1/2"},
+	{`server[name=b] port`, "/1/2"},
+	{"server[route] name", "/0/1"},
+	{"server[1]", "/1"},
+	{"server[-1]", "/1"},
+	{"server[5]", ""},
+	{".. port", "/0/2 /0/3/2/1 /1/2 /2/1"},
+	{"server .. port", "/0/2 /0/3/2/1 /1/2"},
+	{"* port", "/0/2 /1/2 /2/1"},
+	{"*[port=81]", "/1"},
+	{"nothing", ""},
+}
+
+func TestRichQuery(t *testing.T) {
+	xs, err := sx.SX.Parse([]byte(queryDoc))
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+
+	for _, c := range queryCases {
+		rs, err := sx.Query(xs, c.Query)
+		if err != nil {
+			t.Errorf("query failed: %s: %v", c.Query, err)
+			continue
+		}
+
+		var paths []string
+		for _, r := range rs {
+			paths = append(paths, r.Path.String())
+		}
+
+		if strings.Join(paths, " ") != c.Paths {
+			t.Errorf("unexpected result: %s: %v", c.Query, paths)
+		}
+	}
+
+	rs, err := sx.Query(xs, `server[name="b"] port`)
+	if err != nil || len(rs) != 1 || !sx.Equal(rs[0].Value, []interface{}{"port", 81}) {
+		t.Errorf("unexpected value: %v", rs)
+	}
+
+	for _, q := range []string{"", "..", "server[", "[0]", `server[name="a]`} {
+		if _, err := sx.Query(xs, q); err == nil {
+			t.Errorf("expected error: %q", q)
+		}
+	}
+}
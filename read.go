@@ -0,0 +1,51 @@
+package sx
+
+import "io"
+import "fmt"
+import "bufio"
+
+// Reads exactly one value from r, which is parsed using the format. Only the
+// bytes making up the value, and any whitespace and comments preceding it, are
+// consumed; whatever follows the value is left unread in r. This makes it
+// possible to read values interleaved with other data, such as a canonical
+// S-expression header followed by a raw payload.
+//
+// The end of the value is found using length prefixes and list depth, so a
+// bare token or integer at the top level ends at the first character which
+// cannot continue it; that character is not consumed.
+//
+// Verbatim base64 ({...}) is parsed in place, so it is read as a value only if
+// its content is exactly one complete value; otherwise an error is returned.
+//
+// Returns io.EOF if r ends before any value begins, or an *EOFError if it ends
+// partway through a value.
+func ReadValue(r *bufio.Reader, f *Format) (interface{}, error) {
+	src := &readerSource{r: r, max: f.MaxInputBytes}
+	s := scanner{src: src, f: f, checkSub: true}
+
+	if err := s.skipSpace(); err != nil {
+		return nil, err
+	}
+	if _, err := src.peekByte(); err != nil {
+		return nil, err
+	}
+	src.buf = src.buf[:0]
+
+	// Malformed and incomplete values are reported by the parser.
+	serr := s.skipValue(0)
+	if serr != nil && serr != errMalformedValue && serr != io.ErrUnexpectedEOF {
+		return nil, serr
+	}
+
+	vs, err := f.Parse(src.buf)
+	if err != nil {
+		return nil, err
+	}
+	if serr != nil {
+		return nil, serr
+	}
+	if len(vs) != 1 {
+		return nil, fmt.Errorf("expected exactly one value, got %d", len(vs))
+	}
+	return vs[0], nil
+}
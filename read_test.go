@@ -0,0 +1,79 @@
+package sx_test
+
+import "io"
+import "bufio"
+import "errors"
+import "strings"
+import "testing"
+import "github.com/hlandau/sx"
+
+func TestReadValue(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("(3:rpc(4:body5:12345))\x00\xffPAYLOAD"))
+	v, err := sx.ReadValue(r, &sx.Csexp)
+	if err != nil {
+		t.Fatalf("cannot read: %v", err)
+	}
+	if !sx.Equal(v, []interface{}{"rpc", []interface{}{"body", "12345"}}) {
+		t.Errorf("unexpected value: %v", v)
+	}
+	rest, _ := io.ReadAll(r)
+	if string(rest) != "\x00\xffPAYLOAD" {
+		t.Errorf("unexpected remainder: %q", rest)
+	}
+
+	f := sx.SX
+	f.ReadQuotePrefixes = true
+	in := `abc (x "a)\"" |KQ==| #29#) 42 -7 -x ; comment (
+		'(q ,@r) 3"abc" {KDE6eCk=} 9:)(;"|#{}x` + "\n" + `,y 4:tail`
+	expected, err := f.Parse([]byte(in))
+	if err != nil {
+		t.Fatalf("cannot parse: %v", err)
+	}
+
+	// A small buffer exercises values longer than the buffer.
+	r = bufio.NewReaderSize(strings.NewReader(in+" "), 16)
+	for i := 0; ; i++ {
+		v, err := sx.ReadValue(r, &f)
+		if err == io.EOF {
+			if i != len(expected) {
+				t.Errorf("expected %d values, got %d", len(expected), i)
+			}
+			break
+		} else if err != nil {
+			t.Fatalf("cannot read value %d: %v", i, err)
+		}
+		if i >= len(expected) || !sx.Equal(v, expected[i]) {
+			t.Fatalf("unexpected value %d: %v", i, v)
+		}
+	}
+
+	// Tokens end at the first character which cannot continue them.
+	r = bufio.NewReader(strings.NewReader("abc(d)"))
+	if v, _ := sx.ReadValue(r, &sx.SX); v != "abc" {
+		t.Errorf("unexpected value: %v", v)
+	}
+	if c, _ := r.ReadByte(); c != '(' {
+		t.Errorf("unexpected remainder: %c", c)
+	}
+
+	for _, in := range []string{`(a`, `5:ab`, `"abc`, `(a ;)`} {
+		_, err := sx.ReadValue(bufio.NewReader(strings.NewReader(in)), &sx.SX)
+		if !errors.Is(err, sx.ErrUnexpectedEOF) {
+			t.Errorf("expected unexpected EOF: %s: %v", in, err)
+		}
+	}
+
+	if _, err := sx.ReadValue(bufio.NewReader(strings.NewReader(" ; x\n ")), &sx.SX); err != io.EOF {
+		t.Errorf("expected EOF: %v", err)
+	}
+	if _, err := sx.ReadValue(bufio.NewReader(strings.NewReader(")")), &sx.SX); err == nil {
+		t.Errorf("expected error")
+	}
+
+	lf := sx.Csexp
+	lf.MaxAtomLength = 100
+	_, err = sx.ReadValue(bufio.NewReader(strings.NewReader("(999999999999:")), &lf)
+	if !errors.Is(err, sx.ErrAtomTooLong) {
+		t.Errorf("expected atom too long: %v", err)
+	}
+}
@@ -0,0 +1,391 @@
+package sx
+
+import "io"
+import "fmt"
+import "bytes"
+import "bufio"
+import "encoding/base64"
+
+// Finding the extent of values without parsing them, used by ReadValue and
+// ParseParallel. The scanner follows the parser's lexical rules closely
+// enough to find where each value ends, but does not check values in detail.
+// A value it does not understand yields errMalformedValue, and the parser is
+// left to report the actual problem.
+
+var errMalformedValue = fmt.Errorf("malformed value")
+
+// Input to a scanner.
+type scanSource interface {
+	readByte() (byte, error)
+
+	// Returns the next byte without consuming it. If this succeeds, so does the
+	// following readByte.
+	peekByte() (byte, error)
+
+	// Consumes bytes up to and including delim and returns them.
+	readUntil(delim byte) ([]byte, error)
+
+	// Consumes n bytes.
+	readN(n uint64) error
+}
+
+type scanner struct {
+	src scanSource
+	f   *Format
+
+	// If set, the content of verbatim base64 must consist of complete values,
+	// as when the content must parse the same way independently of what
+	// follows the closing '}'.
+	checkSub bool
+
+	sub bool // scanning the decoded content of verbatim base64?
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func (s *scanner) isTokenByte(c byte) bool {
+	return isTokenChar(rune(c)) || (c == '?' && s.f.allowPatternVariables)
+}
+
+// Returns io.ErrUnexpectedEOF in place of io.EOF.
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Skips whitespace and comments, stopping at the end of input.
+func (s *scanner) skipSpace() error {
+	for {
+		c, err := s.src.peekByte()
+		switch {
+		case err == io.EOF:
+			return nil
+		case err != nil:
+			return err
+		case isSpace(c):
+			s.src.readByte()
+		case c == ';' && s.f.allowComments:
+			_, err := s.src.readUntil('\n')
+			if err == io.EOF && s.sub {
+				// The comment would continue past the closing '}'.
+				return errMalformedValue
+			} else if err != nil && err != io.EOF {
+				return err
+			}
+		default:
+			return nil
+		}
+	}
+}
+
+// Skips bytes of the character class fn. A run which reaches the end of the
+// input is complete, except within verbatim base64, where it would continue
+// past the closing '}'.
+func (s *scanner) skipWhile(fn func(c byte) bool) error {
+	for {
+		c, err := s.src.peekByte()
+		if err == io.EOF {
+			if s.sub {
+				return errMalformedValue
+			}
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if !fn(c) {
+			return nil
+		}
+		s.src.readByte()
+	}
+}
+
+// Skips a value, which must begin at the current position. Returns
+// io.ErrUnexpectedEOF if the input ends within it.
+func (s *scanner) skipValue(depth uint) error {
+	f := s.f
+	c, err := s.src.readByte()
+	if err != nil {
+		return unexpected(err)
+	}
+
+	switch {
+	case c == '(' && f.allowLists:
+		if depth >= f.maxListDepth {
+			return ErrDepthLimitExceeded
+		}
+		for {
+			if err := s.skipSpace(); err != nil {
+				return err
+			}
+			c, err := s.src.peekByte()
+			if err != nil {
+				return unexpected(err)
+			}
+			if c == ')' {
+				s.src.readByte()
+				return nil
+			}
+			if err := s.skipValue(depth + 1); err != nil {
+				return err
+			}
+		}
+	case (c == ',' && (f.allowUnquote || f.ReadQuotePrefixes)) ||
+		((c == '\'' || c == '`') && f.ReadQuotePrefixes):
+		if depth >= f.maxListDepth {
+			return ErrDepthLimitExceeded
+		}
+		if c == ',' {
+			if n, _ := s.src.peekByte(); n == '@' {
+				s.src.readByte()
+			}
+		}
+		if err := s.skipSpace(); err != nil {
+			return err
+		}
+		return s.skipValue(depth + 1)
+	case isDigit(c) && f.allowIntegers:
+		return s.skipLengthPrefixed(c)
+	case c == '-' && f.allowIntegers:
+		if n, _ := s.src.peekByte(); isDigit(n) {
+			return s.skipWhile(isDigit)
+		}
+		return s.skipWhile(s.isTokenByte)
+	case c == '"' && f.allowQuotedString:
+		for {
+			c, err := s.src.readByte()
+			if err != nil {
+				return unexpected(err)
+			}
+			switch c {
+			case '"':
+				return nil
+			case '\\':
+				if _, err := s.src.readByte(); err != nil {
+					return unexpected(err)
+				}
+			}
+		}
+	case c == '|' && f.allowBase64BinaryString:
+		_, err := s.src.readUntil('|')
+		return unexpected(err)
+	case c == '#' && f.allowHexBinaryString:
+		_, err := s.src.readUntil('#')
+		return unexpected(err)
+	case c == '{' && f.allowVerbatimBase64BinaryString && !s.sub:
+		enc, err := s.src.readUntil('}')
+		if err != nil {
+			return unexpected(err)
+		}
+		if s.checkSub {
+			return s.checkVerbatimBase64(enc[:len(enc)-1], depth)
+		}
+		return nil
+	case f.allowTokens && (isTokenStartChar(rune(c)) || (c == '?' && f.allowPatternVariables)):
+		return s.skipWhile(s.isTokenByte)
+	}
+	return errMalformedValue
+}
+
+// Skips an integer or, if it is followed by one, the string to which it is a
+// length prefix. c is the first digit, already consumed.
+func (s *scanner) skipLengthPrefixed(c byte) error {
+	f := s.f
+	n := uint64(c - '0')
+	for {
+		c, err := s.src.peekByte()
+		if err == io.EOF {
+			if s.sub {
+				return errMalformedValue
+			}
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		switch {
+		case isDigit(c):
+			s.src.readByte()
+			if n > (1<<64-1-9)/10 {
+				n = 1<<64 - 1
+			} else {
+				n = n*10 + uint64(c-'0')
+			}
+			continue
+		case c == ':' && f.allowVerbatimBinaryString:
+			if f.MaxAtomLength != 0 && n > f.MaxAtomLength {
+				return ErrAtomTooLong
+			}
+			s.src.readByte()
+			return unexpected(s.src.readN(n))
+		case c == '"' && f.allowQuotedString,
+			c == '|' && f.allowBase64BinaryString,
+			c == '#' && f.allowHexBinaryString:
+			s.src.readByte()
+			_, err := s.src.readUntil(c)
+			return unexpected(err)
+		}
+		return nil
+	}
+}
+
+// Checks that the content of verbatim base64 consists of complete values. It
+// is parsed in place, so otherwise a value could continue past the '}'.
+func (s *scanner) checkVerbatimBase64(enc []byte, depth uint) error {
+	stripped := make([]byte, 0, len(enc))
+	for _, c := range enc {
+		if !isSpace(c) {
+			stripped = append(stripped, c)
+		}
+	}
+	dec := make([]byte, base64.StdEncoding.DecodedLen(len(stripped)))
+	n, err := base64.StdEncoding.Decode(dec, stripped)
+	if err != nil {
+		return errMalformedValue
+	}
+
+	src := &sliceSource{b: dec[:n]}
+	sub := scanner{src: src, f: s.f, sub: true}
+	for {
+		if err := sub.skipSpace(); err != nil {
+			return err
+		}
+		if src.i == len(src.b) {
+			return nil
+		}
+		if err := sub.skipValue(depth); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				err = errMalformedValue
+			}
+			return err
+		}
+	}
+}
+
+// Input held in memory.
+type sliceSource struct {
+	b []byte
+	i int
+}
+
+func (s *sliceSource) readByte() (byte, error) {
+	if s.i >= len(s.b) {
+		return 0, io.EOF
+	}
+	s.i++
+	return s.b[s.i-1], nil
+}
+
+func (s *sliceSource) peekByte() (byte, error) {
+	if s.i >= len(s.b) {
+		return 0, io.EOF
+	}
+	return s.b[s.i], nil
+}
+
+func (s *sliceSource) readUntil(delim byte) ([]byte, error) {
+	start := s.i
+	idx := bytes.IndexByte(s.b[s.i:], delim)
+	if idx < 0 {
+		s.i = len(s.b)
+		return s.b[start:], io.EOF
+	}
+	s.i += idx + 1
+	return s.b[start:s.i], nil
+}
+
+func (s *sliceSource) readN(n uint64) error {
+	if n > uint64(len(s.b)-s.i) {
+		s.i = len(s.b)
+		return io.EOF
+	}
+	s.i += int(n)
+	return nil
+}
+
+// Input read from a bufio.Reader. The bytes consumed are collected in buf.
+type readerSource struct {
+	r   *bufio.Reader
+	buf []byte
+	max uint64 // maximum length of buf, or zero
+}
+
+func (s *readerSource) grow(n int) error {
+	if s.max != 0 && uint64(len(s.buf))+uint64(n) > s.max {
+		return ErrInputTooLarge
+	}
+	return nil
+}
+
+func (s *readerSource) readByte() (byte, error) {
+	if err := s.grow(1); err != nil {
+		return 0, err
+	}
+	c, err := s.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	s.buf = append(s.buf, c)
+	return c, nil
+}
+
+// Checks the limit as readByte does, so that readByte cannot fail after
+// peekByte succeeds.
+func (s *readerSource) peekByte() (byte, error) {
+	if err := s.grow(1); err != nil {
+		return 0, err
+	}
+	b, err := s.r.Peek(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (s *readerSource) readUntil(delim byte) ([]byte, error) {
+	start := len(s.buf)
+	for {
+		b, err := s.r.ReadSlice(delim)
+		if gerr := s.grow(len(b)); gerr != nil {
+			return nil, gerr
+		}
+		s.buf = append(s.buf, b...)
+		if err != bufio.ErrBufferFull {
+			return s.buf[start:], err
+		}
+	}
+}
+
+func (s *readerSource) readN(n uint64) error {
+	if s.max != 0 && n > s.max {
+		return ErrInputTooLarge
+	}
+	// Read in pieces so that a bogus length does not cause a large allocation.
+	for n > 0 {
+		m := n
+		if m > 32<<10 {
+			m = 32 << 10
+		}
+		if err := s.grow(int(m)); err != nil {
+			return err
+		}
+		start := len(s.buf)
+		s.buf = append(s.buf, make([]byte, m)...)
+		k, err := io.ReadFull(s.r, s.buf[start:])
+		s.buf = s.buf[:start+k]
+		if err == io.ErrUnexpectedEOF {
+			return io.EOF
+		} else if err != nil {
+			return err
+		}
+		n -= m
+	}
+	return nil
+}
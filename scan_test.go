@@ -0,0 +1,100 @@
+package sx_test
+
+import "io"
+import "bytes"
+import "bufio"
+import "strings"
+import "testing"
+import "github.com/hlandau/sx"
+
+// Inputs exercising each syntactic feature, used to check that ReadValue and
+// ParseParallel find the same values as Parse.
+var scanFeatures = []string{
+	`(a (b c) () ((d)))`,
+	`5:a b)(`,
+	`3"abc" 2"é" 3|YWJj| 3#616263#`,
+	`"a\"b)(\\" "c\` + "\n" + `d"`,
+	`|YWJj| |YW Jj| #616263#`,
+	`{KDE6eCk=} {IHgg} { KDE6 eCk= }`,
+	`abc-def.x/y:z*+= 42 -7 -x 18446744073709551615`,
+	`12abc 3-4`,
+	"; comment (\n(x ; ) \"\n y)",
+	"'a `(b ,c ,@d) ' e ''f , g",
+	"\"café ☺\" (\xff \"\xfe\")",
+	`(a ]`,
+	strings.Repeat("(", 300) + strings.Repeat(")", 300),
+	`(a "unterminated`,
+}
+
+// Verbatim base64 which is not exactly one complete value. Parse accepts it,
+// but ReadValue cannot return it as a single value.
+var scanVerbatimBase64 = []string{
+	`{KDE6eCkoMTp5KQ==}`,
+	`{YWJj}x`,
+}
+
+func TestScanner(t *testing.T) {
+	sxq := sx.SX
+	sxq.ReadQuotePrefixes = true
+	formats := []*sx.Format{&sxq, &sx.Csexp}
+
+	for _, feature := range scanFeatures {
+		for _, f := range formats {
+			for _, sep := range []string{"\n", " ", ""} {
+				var doc []byte
+				for len(doc) < 64<<10 {
+					doc = append(doc, feature+sep...)
+				}
+				checkScanner(t, f, feature, doc)
+			}
+		}
+	}
+
+	for _, in := range scanVerbatimBase64 {
+		doc := []byte(strings.Repeat(in+"\n", 10000))
+		expected, err := sx.SX.Parse(doc)
+		if err != nil {
+			t.Fatalf("cannot parse: %v", err)
+		}
+		vs, err := sx.SX.ParseParallel(doc, 4)
+		if err != nil || !sx.Equal(vs, expected) {
+			t.Errorf("%q: ParseParallel: mismatch: %v", in, err)
+		}
+		if _, err := sx.ReadValue(bufio.NewReader(strings.NewReader(in)), &sx.SX); err == nil {
+			t.Errorf("%q: ReadValue: expected error", in)
+		}
+	}
+}
+
+func checkScanner(t *testing.T, f *sx.Format, feature string, doc []byte) {
+	expected, expectedErr := f.Parse(doc)
+
+	vs, err := f.ParseParallel(doc, 4)
+	if expectedErr != nil {
+		if err == nil || err.Error() != expectedErr.Error() {
+			t.Errorf("%q: ParseParallel: unexpected error: %v, expected %v", feature, err, expectedErr)
+		}
+	} else if err != nil || !sx.Equal(vs, expected) {
+		t.Errorf("%q: ParseParallel: mismatch: %v", feature, err)
+	}
+
+	r := bufio.NewReaderSize(bytes.NewReader(doc), 16)
+	vs = nil
+	for {
+		v, err := sx.ReadValue(r, f)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			if expectedErr == nil {
+				t.Errorf("%q: ReadValue: unexpected error: %v", feature, err)
+			}
+			return
+		}
+		vs = append(vs, v)
+	}
+	if expectedErr != nil {
+		t.Errorf("%q: ReadValue: expected error %v", feature, expectedErr)
+	} else if !sx.Equal(vs, expected) {
+		t.Errorf("%q: ReadValue: mismatch", feature)
+	}
+}
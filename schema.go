@@ -0,0 +1,345 @@
+package sx
+
+import "fmt"
+import "strconv"
+
+// Schema describing the allowed shape of a document.
+//
+// Schemas are themselves S-expressions. Documents are assumed to follow the
+// head yarn convention used by Q1bhy: an element is a list whose first
+// value is a string naming it, followed by its arguments (atoms) and its
+// children (further elements). For example:
+//
+//   (schema
+//     (define endpoint
+//       (args string int))
+//     (required server
+//       (args string)
+//       (optional port (args int))
+//       (repeated listen (args (enum tcp udp) int))
+//       (optional backup (ref endpoint))
+//       (optional tls
+//         (required cert (args string))
+//         (optional ciphers (rest string)))
+//       (open)))
+//
+// The body of (schema ...) describes the top level of the document. An
+// element body may contain:
+//
+//   (args TYPE...)        the arguments the element must have, in order
+//   (rest TYPE)           any number of further arguments of the given type
+//   (CARD HEAD BODY...)   a child element; CARD is one of required (exactly
+//                         one), optional (at most one), repeated (any number)
+//                         or some (at least one)
+//   (ref NAME)            include the body of (define NAME BODY...)
+//   (open)                allow children not described by the schema
+//
+// TYPE is one of string, int, atom (string or int), any, or
+// (enum VALUE...).
+type Schema struct {
+	root *schemaElem
+}
+
+type schemaElem struct {
+	head     string
+	min, max int // max < 0 means unbounded
+	args     []interface{}
+	rest     interface{}
+	hasRest  bool
+	children []*schemaElem
+	open     bool
+	refs     []string
+	included []*schemaElem
+}
+
+// An error found when validating a document against a schema.
+type ValidationError struct {
+	Path    string // e.g. "/server[0]/port"
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	return e.Path + ": " + e.Message
+}
+
+// Parses a (schema ...) value.
+func ParseSchema(v interface{}) (*Schema, error) {
+	if !Hhy(v, "schema") {
+		return nil, fmt.Errorf("not a schema")
+	}
+
+	defs := map[string]*schemaElem{}
+	var all []*schemaElem
+	root, err := parseSchemaBody(v.([]interface{})[1:], defs, &all)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range all {
+		for _, name := range e.refs {
+			d, ok := defs[name]
+			if !ok {
+				return nil, fmt.Errorf("schema: undefined reference: %s", name)
+			}
+			e.included = append(e.included, d)
+		}
+	}
+
+	return &Schema{root: root}, nil
+}
+
+func parseSchemaBody(body []interface{}, defs map[string]*schemaElem, all *[]*schemaElem) (*schemaElem, error) {
+	e := &schemaElem{}
+	*all = append(*all, e)
+
+	for _, x := range body {
+		xs, ok := x.([]interface{})
+		if !ok || len(xs) == 0 {
+			return nil, fmt.Errorf("schema: unexpected value: %v", x)
+		}
+
+		kw, _ := atomString(xs[0])
+		switch kw {
+		case "args":
+			for _, t := range xs[1:] {
+				if err := checkSchemaType(t); err != nil {
+					return nil, err
+				}
+			}
+			e.args = xs[1:]
+		case "rest":
+			if len(xs) != 2 {
+				return nil, fmt.Errorf("schema: rest takes one type")
+			}
+			if err := checkSchemaType(xs[1]); err != nil {
+				return nil, err
+			}
+			e.rest = xs[1]
+			e.hasRest = true
+		case "open":
+			e.open = true
+		case "ref":
+			name, ok := schemaName(xs)
+			if !ok {
+				return nil, fmt.Errorf("schema: malformed ref")
+			}
+			e.refs = append(e.refs, name)
+		case "define":
+			if len(xs) < 2 {
+				return nil, fmt.Errorf("schema: malformed define")
+			}
+			name, ok := schemaName(xs[:2])
+			if !ok {
+				return nil, fmt.Errorf("schema: malformed define")
+			}
+			d, err := parseSchemaBody(xs[2:], defs, all)
+			if err != nil {
+				return nil, err
+			}
+			defs[name] = d
+		case "required", "optional", "repeated", "some":
+			if len(xs) < 2 {
+				return nil, fmt.Errorf("schema: %s requires a head", kw)
+			}
+			head, ok := atomString(xs[1])
+			if !ok {
+				return nil, fmt.Errorf("schema: malformed head: %v", xs[1])
+			}
+			c, err := parseSchemaBody(xs[2:], defs, all)
+			if err != nil {
+				return nil, err
+			}
+			c.head = head
+			c.min, c.max = schemaCardinality(kw)
+			e.children = append(e.children, c)
+		default:
+			return nil, fmt.Errorf("schema: unknown keyword: %v", xs[0])
+		}
+	}
+
+	return e, nil
+}
+
+func schemaName(xs []interface{}) (string, bool) {
+	if len(xs) != 2 {
+		return "", false
+	}
+	return atomString(xs[1])
+}
+
+func schemaCardinality(kw string) (int, int) {
+	switch kw {
+	case "required":
+		return 1, 1
+	case "optional":
+		return 0, 1
+	case "some":
+		return 1, -1
+	default:
+		return 0, -1
+	}
+}
+
+func checkSchemaType(t interface{}) error {
+	if Hhy(t, "enum") {
+		return nil
+	}
+
+	s, _ := atomString(t)
+	switch s {
+	case "string", "int", "atom", "any":
+		return nil
+	default:
+		return fmt.Errorf("schema: unknown type: %v", t)
+	}
+}
+
+// Effective body of an element, including referenced definitions.
+type schemaSpec struct {
+	args     []interface{}
+	rest     interface{}
+	hasRest  bool
+	children []*schemaElem
+	open     bool
+}
+
+func (e *schemaElem) spec(s *schemaSpec, seen map[*schemaElem]bool) {
+	if seen[e] {
+		return
+	}
+	seen[e] = true
+
+	if e.args != nil {
+		s.args = e.args
+	}
+	if e.hasRest {
+		s.rest, s.hasRest = e.rest, true
+	}
+	s.children = append(s.children, e.children...)
+	s.open = s.open || e.open
+
+	for _, d := range e.included {
+		d.spec(s, seen)
+	}
+}
+
+// Validates a document against a schema. Returns all errors found; an empty
+// result means the document is valid.
+func Validate(schema *Schema, doc []interface{}) []ValidationError {
+	var errs []ValidationError
+	validateBody(schema.root, doc, "", &errs)
+	return errs
+}
+
+func validateBody(e *schemaElem, body []interface{}, path string, errs *[]ValidationError) {
+	var s schemaSpec
+	e.spec(&s, map[*schemaElem]bool{})
+
+	fail := func(p, format string, args ...interface{}) {
+		if p == "" {
+			p = "/"
+		}
+		*errs = append(*errs, ValidationError{p, fmt.Sprintf(format, args...)})
+	}
+
+	var args []interface{}
+	counts := map[string]int{}
+	for _, x := range body {
+		head, ok := headYarn(x)
+		if !ok {
+			args = append(args, x)
+			continue
+		}
+
+		var c *schemaElem
+		for _, sc := range s.children {
+			if sc.head == head {
+				c = sc
+				break
+			}
+		}
+
+		if c == nil {
+			if !s.open {
+				fail(path+"/"+head, "unexpected element")
+			}
+			continue
+		}
+
+		p := path + "/" + head
+		if c.max != 1 {
+			p += "[" + strconv.Itoa(counts[head]) + "]"
+		}
+		counts[head]++
+
+		validateBody(c, x.([]interface{})[1:], p, errs)
+	}
+
+	for _, c := range s.children {
+		n := counts[c.head]
+		if n < c.min {
+			fail(path+"/"+c.head, "missing required element")
+		} else if c.max >= 0 && n > c.max {
+			fail(path+"/"+c.head, "element may appear at most %d time(s), found %d", c.max, n)
+		}
+	}
+
+	if len(args) < len(s.args) {
+		fail(path, "expected %d argument(s), found %d", len(s.args), len(args))
+		return
+	}
+
+	if len(args) > len(s.args) && !s.hasRest {
+		fail(path, "expected %d argument(s), found %d", len(s.args), len(args))
+		return
+	}
+
+	for i, a := range args {
+		t := s.rest
+		if i < len(s.args) {
+			t = s.args[i]
+		}
+		if !schemaTypeMatches(t, a) {
+			fail(path, "argument %d: expected %s, found %v", i, schemaTypeString(t), a)
+		}
+	}
+}
+
+// Returns the head yarn of v if it is an element.
+func headYarn(v interface{}) (string, bool) {
+	xs, ok := v.([]interface{})
+	if !ok || len(xs) == 0 {
+		return "", false
+	}
+	return atomString(xs[0])
+}
+
+func schemaTypeMatches(t, v interface{}) bool {
+	if Hhy(t, "enum") {
+		for _, e := range t.([]interface{})[1:] {
+			if Equal(e, v) {
+				return true
+			}
+		}
+		return false
+	}
+
+	s, _ := atomString(t)
+	if s == "any" {
+		return true
+	}
+
+	return matchType(s, v)
+}
+
+func schemaTypeString(t interface{}) string {
+	if s, ok := atomString(t); ok {
+		return s
+	}
+
+	s, err := SX.String([]interface{}{t})
+	if err != nil {
+		return "enum"
+	}
+	return s
+}
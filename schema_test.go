@@ -0,0 +1,73 @@
+package sx_test
+
+import "strings"
+import "testing"
+import "github.com/hlandau/sx"
+
+const testSchema = `
+(schema
+  (define endpoint
+    (args string int))
+  (required server
+    (args string)
+    (optional port (args int))
+    (repeated listen (args (enum tcp udp) int))
+    (optional backup (ref endpoint))
+    (optional tls
+      (required cert (args string))
+      (optional ciphers (rest string)))))
+`
+
+type schemaCase struct {
+	Doc    string
+	Errors []string
+}
+
+var schemaCases = []schemaCase{
+	{`(server alpha (port 80) (listen tcp 80) (listen udp 53) (backup "beta" 81) (tls (cert "a.pem") (ciphers a b c)))`, nil},
+	{`(server alpha)`, nil},
+	{``, []string{"/server: missing required element"}},
+	{`(server alpha) (server beta)`, []string{"/server: element may appear at most 1 time(s), found 2"}},
+	{`(server 42)`, []string{"/server: argument 0: expected string, found 42"}},
+	{`(server alpha (port eighty))`, []string{"/server/port: argument 0: expected int, found eighty"}},
+	{`(server alpha (listen sctp 80))`, []string{"/server/listen[0]: argument 0: expected (enum tcp udp), found sctp"}},
+	{`(server alpha (tls))`, []string{"/server/tls/cert: missing required element"}},
+	{`(server alpha (backup "beta"))`, []string{"/server/backup: expected 2 argument(s), found 1"}},
+	{`(server alpha (colour blue))`, []string{"/server/colour: unexpected element"}},
+}
+
+func TestSchema(t *testing.T) {
+	schema, err := sx.ParseSchema(parseOne(t, testSchema))
+	if err != nil {
+		t.Fatalf("cannot parse schema: %v", err)
+	}
+
+	for _, c := range schemaCases {
+		doc, err := sx.SX.Parse([]byte(c.Doc))
+		if err != nil {
+			t.Fatalf("cannot parse document: %v", err)
+		}
+
+		var errs []string
+		for _, e := range sx.Validate(schema, doc) {
+			errs = append(errs, e.Error())
+		}
+
+		if strings.Join(errs, "\n") != strings.Join(c.Errors, "\n") {
+			t.Errorf("unexpected validation result: %s: %#v", c.Doc, errs)
+		}
+	}
+}
+
+func TestBadSchema(t *testing.T) {
+	for _, s := range []string{
+		"(schema (required a (args float)))",
+		"(schema (required a (ref undefined)))",
+		"(schema (sometimes a))",
+		"(schema (define))",
+	} {
+		if _, err := sx.ParseSchema(parseOne(t, s)); err == nil {
+			t.Errorf("expected error: %s", s)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package sx
+
+import "crypto"
+import "crypto/ecdsa"
+import "crypto/ed25519"
+import "crypto/rand"
+import "crypto/sha256"
+import "crypto/sha512"
+import "fmt"
+import "hash"
+
+// Signatures over S-expressions take the form
+//
+//   (signature
+//     (hash sha256 |...|)
+//     (public-key ...)
+//     (ed25519-sig |...|))
+//
+// The hash is computed over the canonical encoding of the signed value, as
+// produced by CsexpCanonical, and it is the hash which is signed. ECDSA
+// signatures use (ecdsa-sig (r ...) (s ...)) in place of (ed25519-sig ...).
+
+var ErrBadSignature = fmt.Errorf("signature verification failed")
+
+var hashes = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+func canonicalHash(v interface{}, alg string) ([]byte, error) {
+	newHash, ok := hashes[alg]
+	if !ok {
+		return nil, fmt.Errorf("unsupported hash algorithm: %s", alg)
+	}
+
+	h := newHash()
+	err := CsexpCanonical.Write([]interface{}{v}, h)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.Sum(nil), nil
+}
+
+// Signs the canonical encoding of v with an ed25519.PrivateKey or
+// *ecdsa.PrivateKey, returning a (signature ...) value.
+func Sign(v interface{}, priv crypto.PrivateKey) ([]interface{}, error) {
+	digest, err := canonicalHash(v, "sha256")
+	if err != nil {
+		return nil, err
+	}
+
+	var pub crypto.PublicKey
+	var sig []interface{}
+	switch k := priv.(type) {
+	case ed25519.PrivateKey:
+		pub = k.Public()
+		sig = []interface{}{"ed25519-sig", string(ed25519.Sign(k, digest))}
+	case *ecdsa.PrivateKey:
+		pub = k.Public()
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest)
+		if err != nil {
+			return nil, err
+		}
+		sig = []interface{}{"ecdsa-sig",
+			[]interface{}{"r", mpi(r)},
+			[]interface{}{"s", mpi(s)},
+		}
+	default:
+		return nil, ErrUnsupportedKey
+	}
+
+	pubv, err := PublicKeyToSX(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	return []interface{}{"signature",
+		[]interface{}{"hash", "sha256", string(digest)},
+		pubv,
+		sig,
+	}, nil
+}
+
+// Verifies a (signature ...) value over v. Returns the public key which made
+// the signature; it is up to the caller to decide whether that key is
+// trusted.
+func VerifySignature(v interface{}, sig interface{}) (crypto.PublicKey, error) {
+	if !Hhy(sig, "signature") {
+		return nil, fmt.Errorf("not a signature")
+	}
+
+	body := sig.([]interface{})[1:]
+	h := Q1bhyt(body, "hash")
+	if len(h) != 2 {
+		return nil, fmt.Errorf("signature: malformed hash")
+	}
+
+	alg, ok := atomString(h[0])
+	if !ok {
+		return nil, fmt.Errorf("signature: malformed hash")
+	}
+
+	claimed, ok := atomString(h[1])
+	if !ok {
+		return nil, fmt.Errorf("signature: malformed hash")
+	}
+
+	digest, err := canonicalHash(v, alg)
+	if err != nil {
+		return nil, err
+	}
+
+	if string(digest) != claimed {
+		return nil, ErrBadSignature
+	}
+
+	pubv := Q1bhy(body, "public-key")
+	if pubv == nil {
+		return nil, fmt.Errorf("signature: missing public key")
+	}
+
+	pub, err := PublicKeyFromSX(pubv)
+	if err != nil {
+		return nil, err
+	}
+
+	switch k := pub.(type) {
+	case ed25519.PublicKey:
+		s, ok := keyParamString(body, "ed25519-sig")
+		if !ok {
+			return nil, fmt.Errorf("signature: missing ed25519-sig")
+		}
+		if !ed25519.Verify(k, digest, []byte(s)) {
+			return nil, ErrBadSignature
+		}
+	case *ecdsa.PublicKey:
+		params := Q1bhyt(body, "ecdsa-sig")
+		r, ok := keyParamInt(params, "r")
+		if !ok {
+			return nil, fmt.Errorf("signature: missing r")
+		}
+		s, ok := keyParamInt(params, "s")
+		if !ok {
+			return nil, fmt.Errorf("signature: missing s")
+		}
+		if !ecdsa.Verify(k, digest, r, s) {
+			return nil, ErrBadSignature
+		}
+	default:
+		return nil, ErrUnsupportedKey
+	}
+
+	return pub, nil
+}
@@ -0,0 +1,52 @@
+package sx_test
+
+import "crypto"
+import "crypto/ecdsa"
+import "crypto/ed25519"
+import "crypto/elliptic"
+import "crypto/rand"
+import "testing"
+import "github.com/hlandau/sx"
+
+func TestSignature(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("cannot generate key: %v", err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("cannot generate key: %v", err)
+	}
+
+	doc := parseOne(t, `(config (server (name "alpha") (port 8080)))`)
+	other := parseOne(t, `(config (server (name "alpha") (port 8081)))`)
+
+	for _, k := range []crypto.PrivateKey{edKey, ecKey} {
+		sig, err := sx.Sign(doc, k)
+		if err != nil {
+			t.Fatalf("cannot sign: %v", err)
+		}
+
+		// Round trip the signature through the canonical encoding.
+		s, err := sx.CsexpCanonical.String([]interface{}{sig})
+		if err != nil {
+			t.Fatalf("cannot serialize signature: %v", err)
+		}
+
+		sigv := parseOne(t, s)
+
+		pub, err := sx.VerifySignature(doc, sigv)
+		if err != nil {
+			t.Fatalf("signature does not verify: %v", err)
+		}
+
+		if !pub.(interface{ Equal(crypto.PublicKey) bool }).Equal(k.(crypto.Signer).Public()) {
+			t.Fatalf("wrong public key returned")
+		}
+
+		if _, err := sx.VerifySignature(other, sigv); err == nil {
+			t.Fatalf("signature verified for wrong document")
+		}
+	}
+}
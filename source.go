@@ -0,0 +1,297 @@
+package sx
+
+import "fmt"
+import "bytes"
+
+// Source formatting.
+//
+// FormatSource lays out S-expression source text in a canonical way, in the
+// manner of gofmt. Unlike WriteIndent, it works on the source text rather than
+// on parsed values, so comments are preserved and every atom is written
+// exactly as it appears in the source, whether as a token, quoted string,
+// verbatim string, hex or base64.
+//
+// The layout is as follows:
+//
+//   - Each top-level value is written on its own line. Single blank lines
+//     between values are preserved; longer runs are collapsed.
+//   - A list is written on one line if it fits within 80 columns and contains
+//     no comments.
+//   - Otherwise, the leading atoms of the list are written on its first line
+//     and each following value is written on a line of its own, indented by
+//     two spaces. The closing parenthesis follows the last value.
+//   - Comments which follow a value on the same line remain there; other
+//     comments are written on a line of their own.
+//
+// The source must be valid in the SX format. The decoded content of verbatim
+// base64 ({...}) is parsed in place, so it can combine with the source around
+// it; such source cannot be reformatted safely and yields ErrUnformattable.
+
+var ErrUnformattable = fmt.Errorf("source cannot be reformatted without changing its meaning")
+
+type srcKind int
+
+const (
+	srcAtom srcKind = iota
+	srcList
+	srcComment
+)
+
+type srcNode struct {
+	kind     srcKind
+	text     []byte // atom or comment text
+	children []*srcNode
+	trailing bool // comment on the same line as the preceding value
+	blank    bool // preceded by a blank line
+}
+
+// Formats S-expression source text, as sxfmt does. Returns an error if the
+// source is not valid.
+func FormatSource(src []byte) ([]byte, error) {
+	vs, err := SX.Parse(src)
+	if err != nil {
+		return nil, err
+	}
+
+	// The source is valid, so the lexer can only fail where verbatim base64
+	// content combines with the source around it.
+	l := srcLexer{src: src}
+	root, err := l.list(false)
+	if err != nil {
+		return nil, ErrUnformattable
+	}
+
+	var b bytes.Buffer
+	for i, n := range root {
+		if i > 0 {
+			if n.trailing {
+				b.WriteByte(' ')
+			} else {
+				b.WriteByte('\n')
+				if n.blank {
+					b.WriteByte('\n')
+				}
+			}
+		}
+		writeSrcNode(n, &b, 0)
+	}
+	if len(root) > 0 {
+		b.WriteByte('\n')
+	}
+
+	// Verbatim base64 content which is not a sequence of complete values can
+	// still change meaning when the layout around it changes.
+	if out, err := SX.Parse(b.Bytes()); err != nil || !Equal(out, vs) {
+		return nil, ErrUnformattable
+	}
+
+	return b.Bytes(), nil
+}
+
+type srcLexer struct {
+	src []byte
+	i   int
+}
+
+// Lexes a sequence of values up to the closing parenthesis (if inList) or the
+// end of input.
+func (l *srcLexer) list(inList bool) ([]*srcNode, error) {
+	var nodes []*srcNode
+	newlines := 0
+	for l.i < len(l.src) {
+		c := l.src[l.i]
+		switch {
+		case c == '\n':
+			newlines++
+			l.i++
+			continue
+		case c == ' ' || c == '\t' || c == '\r':
+			l.i++
+			continue
+		case c == ')':
+			if !inList {
+				return nil, ErrListEnd
+			}
+			l.i++
+			return nodes, nil
+		}
+
+		var n *srcNode
+		switch c {
+		case '(':
+			l.i++
+			children, err := l.list(true)
+			if err != nil {
+				return nil, err
+			}
+			n = &srcNode{kind: srcList, children: children}
+		case ';':
+			end := bytes.IndexByte(l.src[l.i:], '\n')
+			if end < 0 {
+				end = len(l.src) - l.i
+			}
+			text := bytes.TrimRight(l.src[l.i:l.i+end], " \t\r")
+			n = &srcNode{kind: srcComment, text: text}
+			n.trailing = len(nodes) > 0 && newlines == 0
+			l.i += end
+		default:
+			start := l.i
+			err := l.atom()
+			if err != nil {
+				return nil, err
+			}
+			n = &srcNode{kind: srcAtom, text: l.src[start:l.i]}
+		}
+
+		n.blank = len(nodes) > 0 && newlines > 1
+		newlines = 0
+		nodes = append(nodes, n)
+	}
+
+	if inList {
+		return nil, ErrUnexpectedEOF
+	}
+	return nodes, nil
+}
+
+// Advances over a single atom.
+func (l *srcLexer) atom() error {
+	src := l.src
+	c := src[l.i]
+
+	switch {
+	case c >= '0' && c <= '9':
+		n := 0
+		for l.i < len(src) && src[l.i] >= '0' && src[l.i] <= '9' {
+			n = n*10 + int(src[l.i]-'0')
+			l.i++
+		}
+		if l.i < len(src) {
+			switch src[l.i] {
+			case ':':
+				l.i += 1 + n
+				if l.i > len(src) {
+					return ErrUnexpectedEOF
+				}
+			case '"', '|', '#':
+				return l.delimited(src[l.i])
+			}
+		}
+		return nil
+	case c == '"' || c == '|' || c == '#':
+		return l.delimited(c)
+	case c == '{':
+		if err := l.delimited('}'); err != nil {
+			return err
+		}
+		// Anything abutting the '}' continues the decoded content.
+		if l.i < len(src) && !isSpace(src[l.i]) && src[l.i] != ')' && src[l.i] != ';' {
+			return ErrUnformattable
+		}
+		return nil
+	case c == '-':
+		l.i++
+		for l.i < len(src) && isTokenChar(rune(src[l.i])) {
+			l.i++
+		}
+		return nil
+	default:
+		start := l.i
+		for l.i < len(src) && (isTokenChar(rune(src[l.i])) || src[l.i] >= 0x80) {
+			l.i++
+		}
+		if l.i == start {
+			return &err{rune(c), int64(l.i)}
+		}
+		return nil
+	}
+}
+
+// Advances over a string delimited by the current character and end, which
+// may be escaped with a backslash in quoted strings.
+func (l *srcLexer) delimited(end byte) error {
+	quoted := l.src[l.i] == '"'
+	for l.i++; l.i < len(l.src); l.i++ {
+		switch {
+		case quoted && l.src[l.i] == '\\':
+			l.i++
+		case l.src[l.i] == end:
+			l.i++
+			return nil
+		}
+	}
+	return ErrUnexpectedEOF
+}
+
+// Returns the one-line form of a node, or false if it cannot be written on one
+// line.
+func srcOneLine(n *srcNode) ([]byte, bool) {
+	switch n.kind {
+	case srcAtom:
+		return n.text, bytes.IndexByte(n.text, '\n') < 0
+	case srcComment:
+		return nil, false
+	}
+
+	b := []byte{'('}
+	for i, c := range n.children {
+		s, ok := srcOneLine(c)
+		if !ok {
+			return nil, false
+		}
+		if i > 0 {
+			b = append(b, ' ')
+		}
+		b = append(b, s...)
+	}
+	return append(b, ')'), true
+}
+
+func writeSrcNode(n *srcNode, b *bytes.Buffer, depth int) {
+	if s, ok := srcOneLine(n); ok && (n.kind == srcAtom || depth*2+len(s) <= indentWidth) {
+		b.Write(s)
+		return
+	}
+
+	if n.kind != srcList {
+		b.Write(n.text)
+		return
+	}
+
+	b.WriteByte('(')
+	i := 0
+	for ; i < len(n.children) && n.children[i].kind == srcAtom; i++ {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.Write(n.children[i].text)
+	}
+
+	for ; i < len(n.children); i++ {
+		c := n.children[i]
+		if c.trailing {
+			b.WriteByte(' ')
+		} else if i > 0 {
+			b.WriteByte('\n')
+			if c.blank {
+				b.WriteByte('\n')
+			}
+			writeSrcIndent(b, depth+1)
+		}
+		writeSrcNode(c, b, depth+1)
+	}
+
+	// A closing parenthesis cannot follow a comment on the same line.
+	if len(n.children) > 0 && n.children[len(n.children)-1].kind == srcComment {
+		b.WriteByte('\n')
+		writeSrcIndent(b, depth)
+	}
+	b.WriteByte(')')
+}
+
+func writeSrcIndent(b *bytes.Buffer, depth int) {
+	for j := 0; j < depth; j++ {
+		b.WriteString("  ")
+	}
+}
+
@@ -0,0 +1,474 @@
+package sx
+
+import "fmt"
+import "math/big"
+import "strings"
+import "time"
+
+// SPKI 5-tuple: <issuer, subject, delegate, tag, validity>.
+//
+// Both authorization certificates of the form
+//
+//   (cert (issuer ...) (subject ...) (propagate) (tag ...) (valid ...))
+//
+// and ACL entries of the form
+//
+//   (entry (subject ...) (propagate) (tag ...) (valid ...))
+//
+// are represented as tuples. The issuer of an ACL entry is the verifier
+// itself ("Self") and is represented as nil.
+//
+// Principals are compared structurally (see Equal), so a key and a hash of
+// that key are not considered the same principal.
+type Tuple struct {
+	Issuer    interface{}
+	Subject   interface{}
+	Propagate bool
+	Tag       interface{}
+	Validity  Validity
+}
+
+// Validity period. A zero time means the period is unbounded in that
+// direction.
+type Validity struct {
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+// The date format used by SPKI validity periods, e.g. "1997-01-01_09:00:00".
+const spkiDateFormat = "2006-01-02_15:04:05"
+
+var ErrNotCert = fmt.Errorf("not an SPKI certificate")
+var ErrNotEntry = fmt.Errorf("not an SPKI ACL entry")
+var ErrNoPropagate = fmt.Errorf("certificate chain requires delegation but propagate is not set")
+var ErrPrincipalMismatch = fmt.Errorf("subject does not match issuer of next certificate")
+var ErrTagIntersection = fmt.Errorf("tag intersection is empty")
+var ErrValidityIntersection = fmt.Errorf("validity intersection is empty")
+
+// Parses an SPKI authorization certificate, (cert ...).
+func ParseCert(v interface{}) (*Tuple, error) {
+	if !Hhy(v, "cert") {
+		return nil, ErrNotCert
+	}
+
+	body := v.([]interface{})[1:]
+	issuer := Q1bhyt(body, "issuer")
+	if len(issuer) != 1 {
+		return nil, fmt.Errorf("cert: issuer must have exactly one principal")
+	}
+
+	t, err := parseTupleBody(body, "cert")
+	if err != nil {
+		return nil, err
+	}
+
+	t.Issuer = issuer[0]
+	return t, nil
+}
+
+// Parses an SPKI ACL entry, (entry ...). The issuer is Self (nil).
+func ParseEntry(v interface{}) (*Tuple, error) {
+	if !Hhy(v, "entry") {
+		return nil, ErrNotEntry
+	}
+
+	return parseTupleBody(v.([]interface{})[1:], "entry")
+}
+
+// Parses an SPKI ACL, (acl (entry ...) ...), returning its entries.
+func ParseACL(v interface{}) ([]*Tuple, error) {
+	if !Hhy(v, "acl") {
+		return nil, fmt.Errorf("not an SPKI ACL")
+	}
+
+	var entries []*Tuple
+	for _, x := range v.([]interface{})[1:] {
+		if !Hhy(x, "entry") {
+			continue
+		}
+
+		t, err := ParseEntry(x)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, t)
+	}
+
+	return entries, nil
+}
+
+func parseTupleBody(body []interface{}, what string) (*Tuple, error) {
+	t := &Tuple{}
+
+	subject := Q1bhyt(body, "subject")
+	if len(subject) != 1 {
+		return nil, fmt.Errorf("%s: subject must have exactly one principal", what)
+	}
+	t.Subject = subject[0]
+
+	t.Propagate = Q1bhy(body, "propagate") != nil
+
+	tag := Q1bhyt(body, "tag")
+	if len(tag) != 1 {
+		return nil, fmt.Errorf("%s: tag must have exactly one body", what)
+	}
+	t.Tag = tag[0]
+
+	if valid := Q1bhy(body, "valid"); valid != nil {
+		v, err := parseValidity(valid[1:])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", what, err)
+		}
+		t.Validity = v
+	}
+
+	return t, nil
+}
+
+func parseValidity(xs []interface{}) (Validity, error) {
+	var v Validity
+	for _, x := range xs {
+		var dst *time.Time
+		switch {
+		case Hhy(x, "not-before"):
+			dst = &v.NotBefore
+		case Hhy(x, "not-after"):
+			dst = &v.NotAfter
+		default:
+			return v, fmt.Errorf("unsupported validity condition: %v", x)
+		}
+
+		args := x.([]interface{})[1:]
+		if len(args) != 1 {
+			return v, fmt.Errorf("malformed validity date")
+		}
+
+		s, ok := atomString(args[0])
+		if !ok {
+			return v, fmt.Errorf("malformed validity date")
+		}
+
+		t, err := time.Parse(spkiDateFormat, s)
+		if err != nil {
+			return v, err
+		}
+
+		*dst = t
+	}
+
+	return v, nil
+}
+
+// Returns the intersection of two validity periods. Returns false if the
+// intersection is empty.
+func (v Validity) Intersect(w Validity) (Validity, bool) {
+	r := v
+	if !w.NotBefore.IsZero() && (r.NotBefore.IsZero() || w.NotBefore.After(r.NotBefore)) {
+		r.NotBefore = w.NotBefore
+	}
+	if !w.NotAfter.IsZero() && (r.NotAfter.IsZero() || w.NotAfter.Before(r.NotAfter)) {
+		r.NotAfter = w.NotAfter
+	}
+	if !r.NotBefore.IsZero() && !r.NotAfter.IsZero() && r.NotBefore.After(r.NotAfter) {
+		return r, false
+	}
+	return r, true
+}
+
+// Returns true iff t lies within the validity period.
+func (v Validity) Contains(t time.Time) bool {
+	return (v.NotBefore.IsZero() || !t.Before(v.NotBefore)) &&
+		(v.NotAfter.IsZero() || !t.After(v.NotAfter))
+}
+
+// 5-tuple reduction.
+//
+// Combines <I1,S1,D1,A1,V1> and <I2,S2,D2,A2,V2> into
+// <I1,S2,D2,A1∩A2,V1∩V2>. This requires that D1 is set and that S1 is the
+// same principal as I2.
+func Reduce(a, b *Tuple) (*Tuple, error) {
+	if !a.Propagate {
+		return nil, ErrNoPropagate
+	}
+
+	if !Equal(a.Subject, b.Issuer) {
+		return nil, ErrPrincipalMismatch
+	}
+
+	tag, ok := TagIntersect(a.Tag, b.Tag)
+	if !ok {
+		return nil, ErrTagIntersection
+	}
+
+	v, ok := a.Validity.Intersect(b.Validity)
+	if !ok {
+		return nil, ErrValidityIntersection
+	}
+
+	return &Tuple{
+		Issuer:    a.Issuer,
+		Subject:   b.Subject,
+		Propagate: b.Propagate,
+		Tag:       tag,
+		Validity:  v,
+	}, nil
+}
+
+// Verifies a delegation chain.
+//
+// entry is normally an ACL entry, and certs is the sequence of certificates
+// leading from the subject of entry to the final subject. Returns the
+// reduced tuple, which grants its tag to its subject on behalf of the issuer
+// of entry.
+//
+// Signatures are not checked here.
+func VerifyChain(entry *Tuple, certs []*Tuple) (*Tuple, error) {
+	r := entry
+	for i, c := range certs {
+		var err error
+		r, err = Reduce(r, c)
+		if err != nil {
+			return nil, fmt.Errorf("certificate %d: %w", i, err)
+		}
+	}
+	return r, nil
+}
+
+// Returns true iff the tuple grants the request tag at the given time.
+func (t *Tuple) Permits(request interface{}, at time.Time) bool {
+	if !t.Validity.Contains(at) {
+		return false
+	}
+
+	r, ok := TagIntersect(t.Tag, request)
+	return ok && Equal(r, request)
+}
+
+// Tag intersection.
+//
+// Computes the intersection of two SPKI tag bodies as described in RFC 2693.
+// The special forms (*), (* set ...), (* prefix ...) and (* range ...) are
+// supported. A list is treated as implicitly extended with (*) elements, so
+// (ftp host) ∩ (ftp host dir) = (ftp host dir).
+//
+// The intersection of two distinct ranges is not computed and is treated as
+// empty. Returns false if the intersection is empty.
+func TagIntersect(a, b interface{}) (interface{}, bool) {
+	if isStarAll(a) {
+		return b, true
+	}
+	if isStarAll(b) {
+		return a, true
+	}
+
+	aop := starOp(a)
+	bop := starOp(b)
+	switch {
+	case aop == "set":
+		return intersectSet(a.([]interface{})[2:], b)
+	case bop == "set":
+		return intersectSet(b.([]interface{})[2:], a)
+	case aop == "" && bop != "":
+		a, b = b, a
+		aop, bop = bop, aop
+	}
+
+	if Equal(a, b) {
+		return a, true
+	}
+
+	switch aop {
+	case "prefix":
+		return intersectPrefix(a.([]interface{}), b, bop)
+	case "range":
+		if bop != "" {
+			return nil, false
+		}
+		ok := rangeContains(a.([]interface{}), b)
+		return b, ok
+	case "":
+	default:
+		return nil, false
+	}
+
+	al, aIsList := a.([]interface{})
+	bl, bIsList := b.([]interface{})
+	if !aIsList || !bIsList {
+		return nil, false
+	}
+
+	if len(al) < len(bl) {
+		al, bl = bl, al
+	}
+
+	r := make([]interface{}, len(al))
+	for i := range al {
+		if i >= len(bl) {
+			r[i] = al[i]
+			continue
+		}
+
+		x, ok := TagIntersect(al[i], bl[i])
+		if !ok {
+			return nil, false
+		}
+		r[i] = x
+	}
+
+	return r, true
+}
+
+func isStarAll(v interface{}) bool {
+	xs, ok := v.([]interface{})
+	return ok && len(xs) == 1 && Hhy(xs, "*")
+}
+
+// Returns the operation name of a (* op ...) form, or "".
+func starOp(v interface{}) string {
+	xs, ok := v.([]interface{})
+	if !ok || len(xs) < 2 || !Hhy(xs, "*") {
+		return ""
+	}
+	s, _ := atomString(xs[1])
+	return s
+}
+
+func intersectSet(elems []interface{}, b interface{}) (interface{}, bool) {
+	var rs []interface{}
+	for _, e := range elems {
+		if r, ok := TagIntersect(e, b); ok {
+			rs = append(rs, r)
+		}
+	}
+
+	switch len(rs) {
+	case 0:
+		return nil, false
+	case 1:
+		return rs[0], true
+	default:
+		return append([]interface{}{"*", "set"}, rs...), true
+	}
+}
+
+func intersectPrefix(a []interface{}, b interface{}, bop string) (interface{}, bool) {
+	if len(a) != 3 {
+		return nil, false
+	}
+
+	p, ok := atomString(a[2])
+	if !ok {
+		return nil, false
+	}
+
+	switch bop {
+	case "":
+		s, ok := atomString(b)
+		if !ok || !strings.HasPrefix(s, p) {
+			return nil, false
+		}
+		return b, true
+	case "prefix":
+		bl := b.([]interface{})
+		if len(bl) != 3 {
+			return nil, false
+		}
+		q, ok := atomString(bl[2])
+		switch {
+		case !ok:
+			return nil, false
+		case strings.HasPrefix(q, p):
+			return b, true
+		case strings.HasPrefix(p, q):
+			return a, true
+		}
+	}
+
+	return nil, false
+}
+
+// Checks whether an atom lies within a (* range ordering [lower] [upper])
+// form. Limits are of the form (g x), (ge x), (l x) or (le x).
+func rangeContains(rng []interface{}, v interface{}) bool {
+	if len(rng) < 3 {
+		return false
+	}
+
+	ordering, ok := atomString(rng[2])
+	if !ok {
+		return false
+	}
+
+	s, ok := atomString(v)
+	if !ok {
+		return false
+	}
+
+	for _, lim := range rng[3:] {
+		xs, ok := lim.([]interface{})
+		if !ok || len(xs) != 2 {
+			return false
+		}
+
+		op, ok := atomString(xs[0])
+		if !ok {
+			return false
+		}
+
+		bound, ok := atomString(xs[1])
+		if !ok {
+			return false
+		}
+
+		c, ok := compareOrdered(ordering, s, bound)
+		if !ok {
+			return false
+		}
+
+		switch op {
+		case "g":
+			ok = c > 0
+		case "ge":
+			ok = c >= 0
+		case "l":
+			ok = c < 0
+		case "le":
+			ok = c <= 0
+		default:
+			ok = false
+		}
+		if !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+func compareOrdered(ordering, a, b string) (int, bool) {
+	switch ordering {
+	case "alpha", "time", "date":
+		return strings.Compare(a, b), true
+	case "binary":
+		if len(a) != len(b) {
+			if len(a) < len(b) {
+				return -1, true
+			}
+			return 1, true
+		}
+		return strings.Compare(a, b), true
+	case "numeric":
+		ar, ok := new(big.Rat).SetString(a)
+		if !ok {
+			return 0, false
+		}
+		br, ok := new(big.Rat).SetString(b)
+		if !ok {
+			return 0, false
+		}
+		return ar.Cmp(br), true
+	default:
+		return 0, false
+	}
+}
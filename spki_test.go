@@ -0,0 +1,111 @@
+package sx_test
+
+import "testing"
+import "time"
+import "github.com/hlandau/sx"
+
+func parseOne(t *testing.T, s string) interface{} {
+	xs, err := sx.SX.Parse([]byte(s))
+	if err != nil {
+		t.Fatalf("failed to parse: %s: %v", s, err)
+	}
+	if len(xs) != 1 {
+		t.Fatalf("expected one value: %s", s)
+	}
+	return xs[0]
+}
+
+type tagCase struct {
+	A, B, Out string
+}
+
+var tagCases = []tagCase{
+	{"(*)", "(ftp host)", "(ftp host)"},
+	{"(ftp host)", "(ftp host dir)", "(ftp host dir)"},
+	{"(ftp host)", "(ftp other)", ""},
+	{"(ftp (* set a b c))", "(ftp b)", "(ftp b)"},
+	{"(ftp (* set a b c))", "(ftp (* set b c d))", "(ftp (* set b c))"},
+	{"(web (* prefix \"/pub/\"))", "(web \"/pub/x\")", "(web \"/pub/x\")"},
+	{"(web (* prefix \"/pub/\"))", "(web \"/priv/x\")", ""},
+	{"(web (* prefix \"/pub/\"))", "(web (* prefix \"/pub/a/\"))", "(web (* prefix \"/pub/a/\"))"},
+	{"(spend (* range numeric (ge \"1\") (le \"1000\")))", "(spend \"500\")", "(spend \"500\")"},
+	{"(spend (* range numeric (ge \"1\") (le \"1000\")))", "(spend \"5000\")", ""},
+}
+
+func TestTagIntersect(t *testing.T) {
+	for _, c := range tagCases {
+		r, ok := sx.TagIntersect(parseOne(t, c.A), parseOne(t, c.B))
+		if c.Out == "" {
+			if ok {
+				t.Errorf("expected empty intersection: %s, %s", c.A, c.B)
+			}
+			continue
+		}
+
+		if !ok {
+			t.Errorf("unexpected empty intersection: %s, %s", c.A, c.B)
+			continue
+		}
+
+		if !sx.Equal(r, parseOne(t, c.Out)) {
+			t.Errorf("intersection mismatch: %s, %s: %v", c.A, c.B, r)
+		}
+	}
+}
+
+func TestVerifyChain(t *testing.T) {
+	acl, err := sx.ParseACL(parseOne(t, `
+    (acl
+      (entry
+        (subject (public-key alice))
+        (propagate)
+        (tag (ftp (* set alpha beta)))
+        (valid (not-before "2000-01-01_00:00:00") (not-after "2010-01-01_00:00:00"))))`))
+	if err != nil {
+		t.Fatalf("failed to parse ACL: %v", err)
+	}
+
+	c1, err := sx.ParseCert(parseOne(t, `
+    (cert
+      (issuer (public-key alice))
+      (subject (public-key bob))
+      (tag (ftp alpha))
+      (valid (not-before "2005-01-01_00:00:00")))`))
+	if err != nil {
+		t.Fatalf("failed to parse cert: %v", err)
+	}
+
+	r, err := sx.VerifyChain(acl[0], []*sx.Tuple{c1})
+	if err != nil {
+		t.Fatalf("chain verification failed: %v", err)
+	}
+
+	if r.Issuer != nil || !sx.Equal(r.Subject, parseOne(t, "(public-key bob)")) || r.Propagate {
+		t.Fatalf("unexpected result: %#v", r)
+	}
+
+	at := time.Date(2006, 1, 1, 0, 0, 0, 0, time.UTC)
+	if !r.Permits(parseOne(t, "(ftp alpha)"), at) {
+		t.Fatalf("expected permission")
+	}
+	if r.Permits(parseOne(t, "(ftp beta)"), at) {
+		t.Fatalf("unexpected permission for tag")
+	}
+	if r.Permits(parseOne(t, "(ftp alpha)"), time.Date(2004, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected permission for time")
+	}
+
+	c2, err := sx.ParseCert(parseOne(t, `
+    (cert
+      (issuer (public-key bob))
+      (subject (public-key carol))
+      (tag (*)))`))
+	if err != nil {
+		t.Fatalf("failed to parse cert: %v", err)
+	}
+
+	_, err = sx.VerifyChain(acl[0], []*sx.Tuple{c1, c2})
+	if err == nil {
+		t.Fatalf("expected failure due to missing propagate")
+	}
+}
@@ -2,7 +2,6 @@
 package sx
 
 import "io"
-import "io/ioutil"
 import "fmt"
 import "bufio"
 import "bytes"
@@ -10,6 +9,7 @@ import "strconv"
 import "encoding/base64"
 import "unicode"
 import "unicode/utf8"
+import "sync"
 
 // interface{} is one of
 //   int
@@ -54,12 +54,90 @@ type Format struct {
 	// Allow bare tokens
 	allowTokens bool
 
+	// Allow tokens beginning with '?', used by patterns.
+	allowPatternVariables bool
+
+	// Allow comments: ; to end of line
+	allowComments bool
+
+	// Allow unquote prefixes, used by templates: ,x -> (unquote x) and
+	// ,@x -> (unquote-splicing x)
+	allowUnquote bool
+
 	maxListDepth  uint
 	unicodeStream bool
 
 	serializationMode int
+
+	// Resource limits for parsing untrusted input. Zero means unlimited. To set
+	// limits, copy one of the provided formats and modify the copy:
+	//
+	//   f := sx.SX
+	//   f.MaxAtomLength = 4096
+	//
+	MaxAtomLength uint64 // Maximum length of an atom in bytes.
+	MaxTokens     uint64 // Maximum number of values, including lists.
+	MaxInputBytes uint64 // Maximum number of input bytes.
+	MaxListLength uint64 // Maximum number of values in a single list.
+
+	// Lisp quote prefixes. If ReadQuotePrefixes is set, the parser reads
+	//
+	//   'x -> (quote x)       `x -> (quasiquote x)
+	//   ,x -> (unquote x)     ,@x -> (unquote-splicing x)
+	//
+	// If WriteQuotePrefixes is set, lists of those forms are written using the
+	// prefixes, except in canonical mode.
+	ReadQuotePrefixes  bool
+	WriteQuotePrefixes bool
+
+	// How strings are written in advanced mode. Strings which are not valid
+	// UTF-8, contain NUL or consist largely of control characters are written
+	// as binary, in base64 or, if BinaryHex is set, in hex. Other strings are
+	// written as tokens or quoted strings according to TextPolicy.
+	TextPolicy TextPolicy
+	BinaryHex  bool
+
+	// How invalid UTF-8 is handled when parsing formats which read their input
+	// as UTF-8, such as SX. Binary atoms are not affected.
+	InvalidUTF8 InvalidUTF8Policy
+
+	// If set, Parse returns verbatim strings (5:apple) and tokens as []byte
+	// slices of its input rather than copying them into strings. The values
+	// alias the input, which must therefore not be modified while they are in
+	// use. Other atoms, which must be decoded, are returned as strings as
+	// usual. This has no effect on parsers created with NewParser, which
+	// cannot retain the buffers passed to Write.
+	ZeroCopy bool
 }
 
+type InvalidUTF8Policy int
+
+const (
+	// Replace each invalid byte with U+FFFD.
+	InvalidUTF8Replace InvalidUTF8Policy = iota
+
+	// Fail with an error wrapping ErrInvalidUTF8.
+	InvalidUTF8Strict
+
+	// Keep the invalid bytes as they are.
+	InvalidUTF8Preserve
+)
+
+type TextPolicy int
+
+const (
+	// Write non-ASCII characters literally in quoted strings.
+	TextUTF8 TextPolicy = iota
+
+	// Write non-ASCII characters in quoted strings as \xNN escapes of their
+	// UTF-8 encoding, so that the output is ASCII.
+	TextASCII
+
+	// Write non-ASCII characters in quoted strings as \uXXXX or \UXXXXXXXX
+	// escapes, so that the output is ASCII.
+	TextUnicodeEscape
+)
+
 const (
 	szModeAdvanced = iota
 	szModeCanonical
@@ -79,6 +157,7 @@ var CsexpCanonical Format
 //   Base64 strings |...|                      -> string
 //   Verbatim base64 {...}                     -> (inline item list)
 //   Bare words (including integers)           -> string, int, int64, uint64
+//   Comments ; to end of line                 -> (ignored)
 //
 var SX Format
 
@@ -108,6 +187,7 @@ func init() {
 		allowVerbatimBase64BinaryString: true,
 		allowTokens:                     true,
 		allowHexBinaryString:            true,
+		allowComments:                   true,
 		maxListDepth:                    255,
 		unicodeStream:                   true,
 	}
@@ -125,8 +205,8 @@ func init() {
 type Parser struct {
 	f         *Format
 	state     int
-	s         string
-	b         []byte
+	s         []byte // current atom
+	b         []byte // undecoded base64 of the current atom
 	xL        uint64
 	i         uint64
 	neg       bool
@@ -134,14 +214,31 @@ type Parser struct {
 	sub       bool // is subparser for verbatim {base64} syntax?
 	bytemode  byte
 	reissue   int
-	tokens    []interface{}
-	stack     [][]interface{}
+	tokens    []interface{} // values of all open lists, innermost last
+	stack     []int         // index in tokens at which each open list starts
 	depth     uint
 	eof       bool
-	b64dec    io.Reader
-	b64sr     switchableReader
 	sublexing bool // in verbatim base64 context?
 	subb64    *writeDecoder
+	ntokens   uint64 // number of values pushed
+	nbytes    uint64 // number of input bytes written
+
+	off        int64   // input offset of the next raw byte to be written
+	cur        int64   // input offset of the current character
+	start      int64   // input offset of the start of the current atom
+	subStart   int64   // input offset of the opening brace of verbatim base64
+	listStarts []int64 // input offsets of the open lists
+	prefixed   []bool  // whether each open list was opened by a prefix
+
+	escN      int   // number of digits in a \u or \U escape; 0 if braced
+	escDigits int   // number of digits read so far
+	escStart  int64 // input offset of the escape
+
+	partial []byte // incomplete UTF-8 sequence at the end of the last write
+	rawByte bool   // current character is an invalid byte being preserved
+
+	zeroCopy bool   // return verbatim strings and tokens as slices of the input
+	alias    []byte // slice of the input holding the current atom
 }
 
 const (
@@ -159,18 +256,23 @@ const (
 	pstateQuotedStringOctalEscape3
 	pstateQuotedStringEscapeCR
 	pstateQuotedStringEscapeLF
+	pstateQuotedStringUnicodeEscapeStart
+	pstateQuotedStringUnicodeEscape
 	pstateBase64String
 	pstateToken
 	pstateHexString
 	pstateHexStringOdd
+	pstateComment
+	pstateUnquote
 )
 
 type err struct {
-	r rune
+	r   rune
+	off int64
 }
 
 func (e *err) Error() string {
-	return fmt.Sprintf("invalid token: unexpected character %v", e.r)
+	return fmt.Sprintf("invalid token: unexpected character %q at offset %d", e.r, e.off)
 }
 
 func (p *Parser) init() {
@@ -181,18 +283,56 @@ func (p *Parser) init() {
 
 var ErrDepthLimitExceeded = fmt.Errorf("list depth limit exceeded")
 var ErrListEnd = fmt.Errorf("attempted to close a list while not in a list")
+var ErrAtomTooLong = fmt.Errorf("atom length limit exceeded")
+var ErrTooManyTokens = fmt.Errorf("token count limit exceeded")
+var ErrInputTooLarge = fmt.Errorf("input size limit exceeded")
+var ErrListTooLong = fmt.Errorf("list length limit exceeded")
+var ErrLengthMismatch = fmt.Errorf("string length does not match length prefix")
+var ErrUnexpectedEOF = fmt.Errorf("unexpected end of input")
+var ErrInvalidCodePoint = fmt.Errorf("invalid code point in unicode escape")
+var ErrInvalidUTF8 = fmt.Errorf("invalid UTF-8")
+
+// Returned by Close when the input ends inside an unterminated construct.
+// Unwraps to ErrUnexpectedEOF.
+type EOFError struct {
+	Construct string // "list", "quoted string", etc.
+	Offset    int64  // Input offset at which the construct started.
+}
+
+func (e *EOFError) Error() string {
+	return fmt.Sprintf("%v: unterminated %s starting at offset %d", ErrUnexpectedEOF, e.Construct, e.Offset)
+}
+
+func (e *EOFError) Unwrap() error {
+	return ErrUnexpectedEOF
+}
 
 func (p *Parser) Write(b []byte) (int, error) {
+	p.nbytes += uint64(len(b))
+	if p.f.MaxInputBytes != 0 && p.nbytes > p.f.MaxInputBytes {
+		return 0, ErrInputTooLarge
+	}
+
+	return p.writeInput(b)
+}
+
+func (p *Parser) writeInput(b []byte) (int, error) {
 	if p.sublexing {
 		idx := bytes.IndexByte(b, '}')
 		if idx < 0 {
+			p.off += int64(len(b))
 			return p.subb64.Write(b)
 		} else {
+			p.off += int64(idx + 1)
 			n, err := p.subb64.Write(b[0:idx])
 			if err != nil {
 				return n, err
 			}
 			p.sublexing = false
+			err = p.subb64.Close()
+			if err != nil {
+				return n, fmt.Errorf("invalid verbatim base64 string at offset %d: %w", p.subStart, err)
+			}
 			n2, err := p.write(b[idx+1:])
 			return n + n2, err
 		}
@@ -209,7 +349,7 @@ func (w writerFunc) Write(b []byte) (int, error) {
 
 func isTokenStartChar(r rune) bool {
 	return (r >= 'A' && r <= 'Z') || r == '_' || (r >= 'a' && r <= 'z') ||
-		r == '.' || r == '.' || r == '/' || r == ':' ||
+		r == '.' || r == '/' || r == ':' ||
 		r == '*' || r == '+' || r == '=' || r == '-'
 }
 
@@ -220,9 +360,9 @@ func isTokenChar(r rune) bool {
 func dechex(r rune) (byte, bool) {
 	if r >= '0' && r <= '9' {
 		return byte(r - '0'), true
-	} else if r >= 'a' && r <= 'z' {
+	} else if r >= 'a' && r <= 'f' {
 		return byte(r - 'a' + 10), true
-	} else if r >= 'A' && r <= 'Z' {
+	} else if r >= 'A' && r <= 'F' {
 		return byte(r - 'A' + 10), true
 	} else {
 		return 0, false
@@ -242,6 +382,26 @@ const useUnicode = true
 func (p *Parser) write(b []byte) (int, error) {
 	i := 0
 	var r rune
+
+	// Offsets are only tracked for raw input, not for data decoded from
+	// verbatim base64.
+	raw := !p.sublexing
+
+	if len(p.partial) > 0 {
+		// Complete the character split across writes.
+		np := len(p.partial)
+		b = append(p.partial, b...)
+		p.partial = nil
+		if raw {
+			p.off -= int64(np)
+		}
+		n, err := p.write(b)
+		if n -= np; n < 0 {
+			n = 0
+		}
+		return n, err
+	}
+
 	for {
 		if p.reissue > 0 {
 			p.reissue--
@@ -250,13 +410,31 @@ func (p *Parser) write(b []byte) (int, error) {
 				break
 			}
 
+			if raw {
+				p.cur = p.off + int64(i)
+			}
+
 			if !useUnicode || p.bytemode != 0 {
 				r = rune(b[i])
 				i += 1
 			} else {
+				if !utf8.FullRune(b[i:]) && !p.eof {
+					p.partial = append([]byte(nil), b[i:]...)
+					break
+				}
+
 				var sz int
 				r, sz = utf8.DecodeRune(b[i:])
-				// ignore errors
+				p.rawByte = false
+				if r == utf8.RuneError && sz <= 1 {
+					switch p.f.InvalidUTF8 {
+					case InvalidUTF8Strict:
+						return i, fmt.Errorf("%w at offset %d", ErrInvalidUTF8, p.cur)
+					case InvalidUTF8Preserve:
+						r = rune(b[i])
+						p.rawByte = true
+					}
+				}
 				i += sz
 			}
 		}
@@ -272,50 +450,84 @@ func (p *Parser) write(b []byte) (int, error) {
 				// nop
 			case r >= '0' && r <= '9' && p.f.allowIntegers:
 				p.state = pstateInteger
+				p.start = p.cur
 				p.reissue++
 			case r == '-' && p.f.allowIntegers:
 				p.state = pstateNegIntegerStart
+				p.start = p.cur
 			case r == '(' && p.f.allowLists:
-				if p.depth >= p.f.maxListDepth {
-					return i, ErrDepthLimitExceeded
+				if err := p.openList(p.cur, false); err != nil {
+					return i, err
 				}
-				p.depth++
-				p.stack = append(p.stack, p.tokens)
-				p.tokens = make([]interface{}, 0)
 			case r == ')' && p.f.allowLists:
 				if p.depth == 0 {
 					return i, ErrListEnd
 				}
-				p.depth--
-				ptok := p.stack[len(p.stack)-1]
-				p.stack = p.stack[0 : len(p.stack)-1]
-				ptok = append(ptok, p.tokens)
-				p.tokens = ptok
+				if p.prefixed[len(p.prefixed)-1] {
+					return i, &err{r, p.cur}
+				}
+				if err := p.closeList(); err != nil {
+					return i, err
+				}
 			case r == '"' && p.f.allowQuotedString:
 				p.state = pstateQuotedString
+				p.start = p.cur
 			case r == '|' && p.f.allowBase64BinaryString:
 				p.state = pstateBase64String
-				p.b64dec = base64.NewDecoder(base64.StdEncoding, &filteringReader{&p.b64sr})
+				p.start = p.cur
 			case r == '{' && p.f.allowVerbatimBase64BinaryString && !p.sublexing:
 				p.sublexing = true
+				p.subStart = p.cur
 				p.subb64 = newWriteDecoder(writerFunc(p.write))
-				return p.Write(b[i:]) // i indexes next character, not this one
-			case p.f.allowTokens && isTokenStartChar(r):
+				p.off += int64(i)
+				n, err := p.writeInput(b[i:]) // i indexes next character, not this one
+				return i + n, err
+			case p.f.allowTokens && (isTokenStartChar(r) || (r == '?' && p.f.allowPatternVariables)):
 				p.state = pstateToken
+				p.start = p.cur
 				p.reissue++
 			case p.f.allowHexBinaryString && r == '#':
 				p.state = pstateHexString
+				p.start = p.cur
+			case p.f.allowComments && r == ';':
+				p.state = pstateComment
+			case (p.f.allowUnquote || p.f.ReadQuotePrefixes) && r == ',':
+				p.state = pstateUnquote
+				p.start = p.cur
+			case p.f.ReadQuotePrefixes && (r == '\'' || r == '`'):
+				if err := p.openList(p.cur, true); err != nil {
+					return i, err
+				}
+				if r == '\'' {
+					p.tokens = append(p.tokens, "quote")
+				} else {
+					p.tokens = append(p.tokens, "quasiquote")
+				}
 			default:
-				return i, &err{r}
+				return i, &err{r, p.cur}
 			}
 		case pstateToken:
-			if !isTokenChar(r) {
+			if !isTokenChar(r) && !(r == '?' && p.f.allowPatternVariables) {
 				p.reissue++
 				p.state = pstateDrifting
-				p.push(p.s)
-				p.s = ""
+				if err := p.pushVerbatim(); err != nil {
+					return i, err
+				}
 			} else {
-				p.s += string(r)
+				// Copy the rest of the token in one go if possible.
+				j := i
+				for p.reissue == 0 && j < len(b) && b[j] < 0x80 && isTokenChar(rune(b[j])) {
+					j++
+				}
+
+				if p.zeroCopy && raw && len(p.s) == 0 && p.alias == nil && r < 0x80 {
+					p.alias = b[i-1 : j]
+				} else {
+					p.unalias()
+					p.s = utf8.AppendRune(p.s, r)
+					p.s = append(p.s, b[i:j]...)
+				}
+				i = j
 			}
 		case pstateNegIntegerStart:
 			switch {
@@ -325,7 +537,7 @@ func (p *Parser) write(b []byte) (int, error) {
 				p.reissue++
 			default:
 				p.state = pstateToken
-				p.s = "-"
+				p.s = append(p.s[:0], '-')
 				p.reissue++
 			}
 		case pstateInteger:
@@ -347,27 +559,34 @@ func (p *Parser) write(b []byte) (int, error) {
 				p.state = pstateBase64String
 				p.lenhint = true
 			case r == ':' && p.f.allowVerbatimBinaryString && !p.neg:
+				if p.f.MaxAtomLength != 0 && p.i > p.f.MaxAtomLength {
+					return i, ErrAtomTooLong
+				}
 				p.xL = p.i
 				p.i = 0
 				p.state = pstateLengthByteString
 				p.lenhint = true
 				p.bytemode++
 			default:
+				var v interface{}
 				if p.neg {
 					// These negations work even for INT_MIN since the cast operators
 					// here operate like reinterpret_casts, and -INT_MIN == INT_MIN.
 					if p.i <= 0x80000000 {
-						p.push(-int(p.i))
+						v = -int(p.i)
 					} else {
-						p.push(-int64(p.i))
+						v = -int64(p.i)
 					}
 				} else {
 					if p.i <= 0x7FFFFFFF {
-						p.push(int(p.i))
+						v = int(p.i)
 					} else {
-						p.push(p.i)
+						v = p.i
 					}
 				}
+				if err := p.push(v); err != nil {
+					return i, err
+				}
 				p.i = 0
 				p.neg = false
 				p.reissue++
@@ -377,135 +596,225 @@ func (p *Parser) write(b []byte) (int, error) {
 			if p.xL == 0 {
 				p.bytemode--
 				p.state = pstateDrifting
-				p.push(p.s)
-				p.s = ""
+				p.lenhint = false
+				if err := p.pushVerbatim(); err != nil {
+					return i, err
+				}
 				p.reissue++
 			} else {
-				p.s += string([]byte{byte(r)})
-				p.xL--
+				// Copy as much of the string as is available in one go. r is b[i-1],
+				// since characters are bytes here.
+				n := uint64(len(b) - (i - 1))
+				if n > p.xL {
+					n = p.xL
+				}
+				if p.zeroCopy && raw && len(p.s) == 0 && n == p.xL {
+					p.alias = b[i-1 : i-1+int(n)]
+				} else {
+					p.s = append(p.s, b[i-1:i-1+int(n)]...)
+				}
+				i += int(n) - 1
+				p.xL -= n
 			}
 		case pstateLengthQuotedString:
 			if p.xL == 0 {
 				if r != '"' {
-					// error
+					return i, ErrLengthMismatch
 				}
 				p.state = pstateDrifting
-				p.push(p.s)
-				p.s = ""
+				if err := p.push(string(p.s)); err != nil {
+					return i, err
+				}
+				p.s = p.s[:0]
 				// consume trailing quote
+			} else if r == '"' {
+				return i, ErrLengthMismatch
 			} else {
-				p.s += string(r)
-				p.xL--
+				// The length prefix counts bytes, as for verbatim strings.
+				n := len(p.s)
+				p.appendChar(r)
+				if uint64(len(p.s)-n) > p.xL {
+					return i, ErrLengthMismatch
+				}
+				p.xL -= uint64(len(p.s) - n)
 			}
 		case pstateQuotedString:
 			switch r {
 			case '"':
 				p.state = pstateDrifting
-				p.push(p.s)
-				p.s = ""
+				if err := p.push(string(p.s)); err != nil {
+					return i, err
+				}
+				p.s = p.s[:0]
 			case '\\':
 				p.state = pstateQuotedStringEscape
 			default:
-				p.s += string(r)
+				p.appendChar(r)
+
+				// Copy any following run of plain ASCII in one go.
+				j := i
+				for p.reissue == 0 && j < len(b) && b[j] >= 0x20 && b[j] < 0x7F && b[j] != '"' && b[j] != '\\' {
+					j++
+				}
+				p.s = append(p.s, b[i:j]...)
+				i = j
 			}
 		case pstateQuotedStringEscape:
 			p.state = pstateQuotedString
 			switch r {
 			case 'a':
-				p.s += "\a"
+				p.s = append(p.s, '\a')
 			case 'b':
-				p.s += "\b"
+				p.s = append(p.s, '\b')
 			case 'f':
-				p.s += "\f"
+				p.s = append(p.s, '\f')
 			case 'n':
-				p.s += "\n"
+				p.s = append(p.s, '\n')
 			case 'r':
-				p.s += "\r"
+				p.s = append(p.s, '\r')
 			case 't':
-				p.s += "\t"
+				p.s = append(p.s, '\t')
 			case 'v':
-				p.s += "\v"
+				p.s = append(p.s, '\v')
 			case '\r':
 				p.state = pstateQuotedStringEscapeLF
 			case '\n':
 				p.state = pstateQuotedStringEscapeCR
 			case 'x':
 				p.state = pstateQuotedStringHexEscape
+			case 'u':
+				p.state = pstateQuotedStringUnicodeEscapeStart
+				p.escStart = p.cur - 1
+			case 'U':
+				p.state = pstateQuotedStringUnicodeEscape
+				p.escStart = p.cur - 1
+				p.escN, p.escDigits, p.i = 8, 0, 0
 			default:
 				if r >= '0' && r <= '7' {
 					p.state = pstateQuotedStringOctalEscape
 					p.i = 0
 					p.reissue++
 				} else {
-					p.s += string(r)
+					p.appendChar(r)
 				}
 			}
 		case pstateQuotedStringHexEscape:
 			v, ok := dechex(r)
 			if !ok {
-				return i, &err{r}
+				return i, &err{r, p.cur}
 			}
 			p.i = uint64(v)
 			p.state = pstateQuotedStringHexEscape2
 		case pstateQuotedStringHexEscape2:
 			v, ok := dechex(r)
 			if !ok {
-				return i, &err{r}
+				return i, &err{r, p.cur}
 			}
-			p.s += string([]byte{byte(p.i<<4) | v})
+			p.s = append(p.s, byte(p.i<<4)|v)
 			p.state = pstateQuotedString
 			p.i = 0
 		case pstateQuotedStringOctalEscape, pstateQuotedStringOctalEscape2, pstateQuotedStringOctalEscape3:
 			v, ok := decoct(r)
 			if !ok {
-				return i, &err{r}
+				return i, &err{r, p.cur}
 			}
 			p.i = uint64(byte(p.i<<3) | v)
 			if p.state == pstateQuotedStringOctalEscape3 {
 				p.state = pstateQuotedString
-				p.s += string([]byte{byte(p.i)})
+				p.s = append(p.s, byte(p.i))
 				p.i = 0
 			} else {
 				p.state++
 			}
+		case pstateQuotedStringUnicodeEscapeStart:
+			// \uXXXX or \u{X...}
+			p.state = pstateQuotedStringUnicodeEscape
+			p.escN, p.escDigits, p.i = 4, 0, 0
+			if r == '{' {
+				p.escN = 0
+			} else {
+				p.reissue++
+			}
+		case pstateQuotedStringUnicodeEscape:
+			if p.escN == 0 && r == '}' && p.escDigits > 0 {
+				if err := p.unicodeEscape(); err != nil {
+					return i, err
+				}
+				break
+			}
+
+			v, ok := dechex(r)
+			if !ok || (p.escN == 0 && p.escDigits == 6) {
+				return i, &err{r, p.cur}
+			}
+			p.i = p.i<<4 | uint64(v)
+			p.escDigits++
+			if p.escDigits == p.escN {
+				if err := p.unicodeEscape(); err != nil {
+					return i, err
+				}
+			}
 		case pstateQuotedStringEscapeLF:
 			if r != '\n' {
 				p.reissue++
 			}
 			p.state = pstateQuotedString
 		case pstateQuotedStringEscapeCR:
-			if r == '\r' {
+			if r != '\r' {
 				p.reissue++
 			}
 			p.state = pstateQuotedString
 		case pstateBase64String:
-			// i indexes the nest character to read, not the current one, so -1 everything
-			idx := bytes.IndexByte(b[i-1:], '|')
+			// i indexes the next character to read, not the current one, so -1
+			// everything. The base64 is accumulated and decoded in one go when the
+			// closing '|' is reached.
+			seg := b[i-1:]
+			idx := bytes.IndexByte(seg, '|')
 			if idx < 0 {
-				p.b64sr.Reader = bytes.NewReader(b[i-1:])
 				i = len(b)
 			} else {
-				p.b64sr.Reader = bytes.NewReader(b[i-1 : i-1+idx])
+				seg = seg[:idx]
 				i += idx
 			}
-			buf, _ := ioutil.ReadAll(p.b64dec)
-			p.s += string(buf)
+			if bytes.IndexAny(seg, " \t\r\n") < 0 {
+				p.b = append(p.b, seg...)
+			} else {
+				for _, c := range seg {
+					if c != ' ' && c != '\t' && c != '\r' && c != '\n' {
+						p.b = append(p.b, c)
+					}
+				}
+			}
+			if p.f.MaxAtomLength != 0 && uint64(len(p.b)/4*3) > p.f.MaxAtomLength+3 {
+				return i, ErrAtomTooLong
+			}
 			if idx >= 0 {
+				if err := p.decodeBase64(); err != nil {
+					return i, err
+				}
+				if p.f.MaxAtomLength != 0 && uint64(len(p.s)) > p.f.MaxAtomLength {
+					return i, ErrAtomTooLong
+				}
 				if p.lenhint && uint64(len(p.s)) != p.xL {
-					return i, &err{r}
+					return i, ErrLengthMismatch
 				}
 				p.state = pstateDrifting
-				p.push(p.s)
-				p.s = ""
+				p.lenhint = false
+				if err := p.push(string(p.s)); err != nil {
+					return i, err
+				}
+				p.s = p.s[:0]
 			}
 		case pstateHexString:
 			if r == '#' {
 				if p.lenhint && uint64(len(p.s)) != p.xL {
-					return i, &err{r}
+					return i, ErrLengthMismatch
 				}
 				p.state = pstateDrifting
-				p.push(p.s)
-				p.s = ""
+				if err := p.push(string(p.s)); err != nil {
+					return i, err
+				}
+				p.s = p.s[:0]
 				p.i = 0
 				p.lenhint = false
 			} else if r == ' ' || r == '\r' || r == '\n' || r == '\t' {
@@ -513,7 +822,7 @@ func (p *Parser) write(b []byte) (int, error) {
 			} else {
 				hv, ok := dechex(r)
 				if !ok {
-					return i, &err{r}
+					return i, &err{r, p.cur}
 				}
 
 				p.i = uint64(hv)
@@ -526,30 +835,235 @@ func (p *Parser) write(b []byte) (int, error) {
 			} else {
 				hv, ok := dechex(r)
 				if !ok {
-					return i, &err{r}
+					return i, &err{r, p.cur}
 				}
 
-				p.s += string([]byte{byte((byte(p.i) << 4) | hv)})
+				p.s = append(p.s, byte((byte(p.i)<<4)|hv))
 				p.state = pstateHexString
 			}
+		case pstateComment:
+			if r == '\n' {
+				p.state = pstateDrifting
+			}
+		case pstateUnquote:
+			if err := p.openList(p.start, true); err != nil {
+				return i, err
+			}
+			if r == '@' {
+				p.tokens = append(p.tokens, "unquote-splicing")
+			} else {
+				p.tokens = append(p.tokens, "unquote")
+				p.reissue++
+			}
+			p.state = pstateDrifting
 		default:
 			panic("invalid state")
 		}
+
+		if p.f.MaxAtomLength != 0 && uint64(len(p.s)) > p.f.MaxAtomLength {
+			return i, ErrAtomTooLong
+		}
+	}
+
+	if raw {
+		p.off += int64(len(b))
 	}
 	return len(b), nil
 }
 
-func (p *Parser) push(tok interface{}) {
+func (p *Parser) push(tok interface{}) error {
+	p.ntokens++
+	if p.f.MaxTokens != 0 && p.ntokens > p.f.MaxTokens {
+		return ErrTooManyTokens
+	}
+	if p.depth > 0 && p.f.MaxListLength != 0 && uint64(len(p.tokens)-p.stack[len(p.stack)-1]) >= p.f.MaxListLength {
+		return ErrListTooLong
+	}
 	p.tokens = append(p.tokens, tok)
+
+	// A prefixed value is complete once the value following the prefix has
+	// been pushed.
+	if n := len(p.prefixed); n > 0 && p.prefixed[n-1] && len(p.tokens)-p.stack[n-1] == 2 {
+		return p.closeList()
+	}
+	return nil
 }
 
+// Pushes the current verbatim string or token, as a []byte if zero-copy
+// parsing.
+func (p *Parser) pushVerbatim() error {
+	if !p.zeroCopy {
+		v := string(p.s)
+		p.s = p.s[:0]
+		return p.push(v)
+	}
+
+	v := p.alias
+	if v == nil {
+		v = append([]byte{}, p.s...)
+	}
+	p.alias = nil
+	p.s = p.s[:0]
+
+	if p.f.MaxAtomLength != 0 && uint64(len(v)) > p.f.MaxAtomLength {
+		return ErrAtomTooLong
+	}
+	return p.push(v[:len(v):len(v)])
+}
+
+// Moves an aliased atom into p.s, so that more may be appended to it.
+func (p *Parser) unalias() {
+	if p.alias != nil {
+		p.s = append(p.s, p.alias...)
+		p.alias = nil
+	}
+}
+
+// Decodes the accumulated base64 into p.s.
+func (p *Parser) decodeBase64() error {
+	n := base64.StdEncoding.DecodedLen(len(p.b))
+	if cap(p.s) < n {
+		p.s = make([]byte, n)
+	}
+	n, err := base64.StdEncoding.Decode(p.s[:n], p.b)
+	p.s = p.s[:n]
+	p.b = p.b[:0]
+	if err != nil {
+		return fmt.Errorf("invalid base64 string at offset %d: %v", p.start, err)
+	}
+	return nil
+}
+
+// Appends a character read from the input to the current atom.
+func (p *Parser) appendChar(r rune) {
+	if p.bytemode != 0 || p.rawByte {
+		p.s = append(p.s, byte(r))
+	} else {
+		p.s = utf8.AppendRune(p.s, r)
+	}
+}
+
+// Completes a \u or \U escape with the code point in p.i.
+func (p *Parser) unicodeEscape() error {
+	if p.i > unicode.MaxRune || (p.i >= 0xD800 && p.i <= 0xDFFF) {
+		return fmt.Errorf("%w: U+%04X at offset %d", ErrInvalidCodePoint, p.i, p.escStart)
+	}
+
+	p.s = utf8.AppendRune(p.s, rune(p.i))
+	p.state = pstateQuotedString
+	p.i = 0
+	return nil
+}
+
+// Begins a list. A prefixed list is closed automatically after its second
+// value is pushed.
+func (p *Parser) openList(start int64, prefixed bool) error {
+	if p.depth >= p.f.maxListDepth {
+		return ErrDepthLimitExceeded
+	}
+	p.depth++
+	p.listStarts = append(p.listStarts, start)
+	p.prefixed = append(p.prefixed, prefixed)
+	p.stack = append(p.stack, len(p.tokens))
+	return nil
+}
+
+// Ends the innermost list and pushes it as a value.
+func (p *Parser) closeList() error {
+	p.depth--
+	p.listStarts = p.listStarts[0 : len(p.listStarts)-1]
+	p.prefixed = p.prefixed[0 : len(p.prefixed)-1]
+	start := p.stack[len(p.stack)-1]
+	p.stack = p.stack[0 : len(p.stack)-1]
+	l := make([]interface{}, len(p.tokens)-start)
+	copy(l, p.tokens[start:])
+	for i := start; i < len(p.tokens); i++ {
+		p.tokens[i] = nil
+	}
+	p.tokens = p.tokens[:start]
+	return p.push(l)
+}
+
+// Signals the end of input. Returns an *EOFError if the input ended inside an
+// unterminated construct.
 func (p *Parser) Close() error {
+	if p.sublexing {
+		return &EOFError{"verbatim base64 string", p.subStart}
+	}
+
+	var construct string
+	switch p.state {
+	case pstateLengthByteString:
+		if p.xL > 0 {
+			construct = "verbatim string"
+		}
+	case pstateLengthQuotedString, pstateQuotedString, pstateQuotedStringEscape,
+		pstateQuotedStringHexEscape, pstateQuotedStringHexEscape2,
+		pstateQuotedStringOctalEscape, pstateQuotedStringOctalEscape2,
+		pstateQuotedStringOctalEscape3, pstateQuotedStringEscapeCR,
+		pstateQuotedStringEscapeLF, pstateQuotedStringUnicodeEscapeStart,
+		pstateQuotedStringUnicodeEscape:
+		construct = "quoted string"
+	case pstateBase64String:
+		construct = "base64 string"
+	case pstateHexString, pstateHexStringOdd:
+		construct = "hex string"
+	}
+	if construct != "" {
+		return &EOFError{construct, p.start}
+	}
+
 	p.eof = true
-	_, err := p.Write([]byte{0})
-	return err
+	_, err := p.writeInput([]byte{0})
+	if err != nil {
+		return err
+	}
+
+	if p.depth > 0 {
+		construct := "list"
+		if p.prefixed[len(p.prefixed)-1] {
+			construct = "prefixed value"
+		}
+		return &EOFError{construct, p.listStarts[len(p.listStarts)-1]}
+	}
+
+	return nil
 }
 
+// Reads input from r until EOF or an error and writes it to the parser. The
+// parser is not closed; call Close once all input has been written. Input may
+// be split across reads at any point.
+func (p *Parser) ReadFrom(r io.Reader) (int64, error) {
+	var buf [32 << 10]byte
+	var n int64
+	for {
+		m, err := r.Read(buf[:])
+		if m > 0 {
+			n += int64(m)
+			_, werr := p.Write(buf[:m])
+			if werr != nil {
+				return n, werr
+			}
+		}
+		if err == io.EOF {
+			return n, nil
+		} else if err != nil {
+			return n, err
+		}
+	}
+}
+
+// Returns the input offset of the character most recently processed. After
+// Write returns an error, this is the offset of the offending character.
+func (p *Parser) Offset() int64 {
+	return p.cur
+}
+
+// Returns the top-level values parsed so far.
 func (p *Parser) Tokens() []interface{} {
+	if len(p.stack) > 0 {
+		return p.tokens[:p.stack[0]]
+	}
 	return p.tokens
 }
 
@@ -562,22 +1076,93 @@ func (fmt *Format) NewParser() *Parser {
 	return p
 }
 
+// Returns the parser to its initial state so that it can be used to parse
+// another input in the same format. Buffers allocated by the parser are
+// retained. Values previously returned by Tokens are not affected.
+func (p *Parser) Reset() {
+	p.tokens = nil
+	p.reset()
+}
+
+func (p *Parser) reset() {
+	for i := range p.tokens {
+		p.tokens[i] = nil
+	}
+
+	*p = Parser{
+		f:          p.f,
+		s:          p.s[:0],
+		b:          p.b[:0],
+		tokens:     p.tokens[:0],
+		stack:      p.stack[:0],
+		listStarts: p.listStarts[:0],
+		prefixed:   p.prefixed[:0],
+	}
+	p.init()
+}
+
+// Parsers used by Parse. Buffers larger than maxPooledBuffer are not retained.
+var parserPool sync.Pool
+
+const maxPooledBuffer = 64 << 10
+
 // Parse a S-expression string and return a slice of the values parsed or an
 // error.
 func (fmt *Format) Parse(b []byte) ([]interface{}, error) {
-	p := fmt.NewParser()
+	p, _ := parserPool.Get().(*Parser)
+	if p == nil {
+		p = &Parser{}
+	}
+	p.f = fmt
+	p.reset()
+	p.zeroCopy = fmt.ZeroCopy
+	defer parserPool.Put(p)
+
 	_, err := p.Write(b)
+	if err == nil {
+		err = p.Close()
+	}
+
+	var vs []interface{}
+	if err == nil {
+		vs = make([]interface{}, len(p.Tokens()))
+		copy(vs, p.Tokens())
+	}
+
+	p.reset()
+	if cap(p.s) > maxPooledBuffer {
+		p.s = nil
+	}
+	if cap(p.b) > maxPooledBuffer {
+		p.b = nil
+	}
+	if cap(p.tokens) > maxPooledBuffer {
+		p.tokens = nil
+	}
+	p.f = nil
+	return vs, err
+}
+
+// Parse S-expressions read from r until EOF and return a slice of the values
+// parsed or an error. The input is read in chunks rather than all at once.
+func (fmt *Format) ParseReader(r io.Reader) ([]interface{}, error) {
+	p := fmt.NewParser()
+	_, err := p.ReadFrom(r)
 	if err != nil {
 		return nil, err
 	}
+
 	err = p.Close()
 	if err != nil {
 		return nil, err
 	}
+
 	return p.Tokens(), nil
 }
 
-// Writes the slice as an S-expression string to the io.Writer.
+// Writes the slice as an S-expression string to the io.Writer. Values of other
+// types which implement Marshaler or encoding.TextMarshaler are converted as
+// by Marshal.
 func (fmt *Format) Write(vs []interface{}, w io.Writer) error {
 	return write(vs, w, fmt)
 }
@@ -627,16 +1212,25 @@ func (s *spacer) write(b *bufio.Writer, t rune) {
 	s.prevType = t
 }
 
+// Binary strings are those which are not valid UTF-8, contain NUL, or of
+// which more than a quarter of the characters are control characters other
+// than whitespace.
 func isBinary(s string) bool {
-  for i := range s {
-    c := s[i]
-    // Unicode strings should not contain 0 bytes or bytes with the two most
-    // significant bits set
-    if c == 0 || (c & 0xC0) == 0xC0 {
+  if !utf8.ValidString(s) {
+    return true
+  }
+  n, ctl := 0, 0
+  for _, r := range s {
+    n++
+    switch {
+    case r == 0:
       return true
+    case r == '\t' || r == '\n' || r == '\r':
+    case r < 0x20 || (r >= 0x7F && r < 0xA0):
+      ctl++
     }
   }
-  return false
+  return ctl*4 > n
 }
 
 func usesTokenCharset(s string) bool {
@@ -667,8 +1261,25 @@ func enchex(x byte) rune {
 
 func writeQuotedString(s string, b *bufio.Writer, f *Format) {
   b.WriteRune('"')
-  for i := range s {
-    c := s[i] // don't decode as runes
+  for i := 0; i < len(s); i++ {
+    c := s[i] // don't decode as runes, except to write UTF-8 literally
+    if c >= 0x80 && f.TextPolicy != TextASCII {
+      r, sz := utf8.DecodeRuneInString(s[i:])
+      if r != utf8.RuneError && f.TextPolicy == TextUnicodeEscape {
+        if r > 0xFFFF {
+          fmt.Fprintf(b, `\U%08x`, r)
+        } else {
+          fmt.Fprintf(b, `\u%04x`, r)
+        }
+        i += sz - 1
+        continue
+      }
+      if r != utf8.RuneError && unicode.IsPrint(r) {
+        b.WriteString(s[i : i+sz])
+        i += sz - 1
+        continue
+      }
+    }
     switch c {
     case '\r':
       b.WriteString(`\r`)
@@ -705,9 +1316,20 @@ func writeBase64String(s string, b *bufio.Writer, f *Format) {
   b.WriteRune('|')
 }
 
+func writeHexString(s string, b *bufio.Writer, f *Format) {
+  b.WriteRune('#')
+  for i := 0; i < len(s); i++ {
+    b.WriteRune(enchex(s[i] >> 4))
+    b.WriteRune(enchex(s[i] & 0x0F))
+  }
+  b.WriteRune('#')
+}
+
 func writeString(s string, b *bufio.Writer, f *Format) {
   if f.serializationMode == szModeAdvanced {
-    if isBinary(s) {
+    if isBinary(s) && f.BinaryHex {
+      writeHexString(s, b, f)
+    } else if isBinary(s) {
       writeBase64String(s, b, f)
     } else if usesTokenCharset(s) {
       writeToken(s, b, f)
@@ -722,9 +1344,48 @@ func writeString(s string, b *bufio.Writer, f *Format) {
   b.WriteString(s)
 }
 
+var quotePrefixes = map[string]string{
+	"quote":            "'",
+	"quasiquote":       "`",
+	"unquote":          ",",
+	"unquote-splicing": ",@",
+}
+
+// Returns the prefix with which the list may be written, if any.
+func quotePrefix(xs []interface{}, f *Format) (string, bool) {
+	if !f.WriteQuotePrefixes || f.serializationMode != szModeAdvanced || len(xs) != 2 {
+		return "", false
+	}
+
+	head, ok := xs[0].(string)
+	if !ok {
+		return "", false
+	}
+
+	prefix, ok := quotePrefixes[head]
+	return prefix, ok
+}
+
+// Converts a value of a type other than those written by writeList using
+// Marshal. Lists are returned as is, since their elements are converted as they
+// are written.
+func marshalElement(v interface{}) (interface{}, error) {
+	switch v.(type) {
+	case string, []byte, int, int64, uint64, []interface{}:
+		return v, nil
+	default:
+		return Marshal(v)
+	}
+}
+
 func writeList(vs []interface{}, b *bufio.Writer, f *Format) error {
 	spacer := spacer{f: f}
 	for _, v := range vs {
+		v, err := marshalElement(v)
+		if err != nil {
+			return err
+		}
+
 		switch vv := v.(type) {
 		case string:
 			spacer.write(b, 's')
@@ -751,6 +1412,36 @@ func writeList(vs []interface{}, b *bufio.Writer, f *Format) error {
 				b.WriteRune(' ')
 			}
 		case []interface{}:
+			if _, ok := quotePrefix(vv, f); ok {
+				// Nested prefix forms are written as consecutive prefixes, so the
+				// spacing depends on the innermost value.
+				var prefixes []byte
+				var x interface{} = vv
+				for {
+					xs, _ := x.([]interface{})
+					prefix, ok := quotePrefix(xs, f)
+					if !ok {
+						break
+					}
+					prefixes = append(prefixes, prefix...)
+					x, err = marshalElement(xs[1])
+					if err != nil {
+						return err
+					}
+				}
+
+				if _, isList := x.([]interface{}); isList {
+					spacer.write(b, '(')
+				} else {
+					spacer.write(b, 's')
+				}
+				b.Write(prefixes)
+				if err := writeList([]interface{}{x}, b, f); err != nil {
+					return err
+				}
+				continue
+			}
+
 			spacer.write(b, '(')
 			b.WriteRune('(')
 			if err := writeList(vv, b, f); err != nil {
@@ -1,6 +1,12 @@
 package sx_test
 
+import "bytes"
+import "encoding/base64"
+import "errors"
+import "io"
+import "strings"
 import "testing"
+import "testing/iotest"
 import "github.com/hlandau/sx"
 
 type testCase struct {
@@ -149,3 +155,598 @@ func TestQuery(t *testing.T) {
 		t.Fatalf("mismatch: %#v", out)
 	}
 }
+
+type limitCase struct {
+	In    string
+	Limit func(f *sx.Format)
+	Err   error
+}
+
+var limitCases = []limitCase{
+	{"999999999999:", func(f *sx.Format) { f.MaxAtomLength = 1024 }, sx.ErrAtomTooLong},
+	{`"abcdefghij"`, func(f *sx.Format) { f.MaxAtomLength = 4 }, sx.ErrAtomTooLong},
+	{"abcdefghij", func(f *sx.Format) { f.MaxAtomLength = 4 }, sx.ErrAtomTooLong},
+	{"|YWJjZGVmZ2hpag==|", func(f *sx.Format) { f.MaxAtomLength = 4 }, sx.ErrAtomTooLong},
+	{"(a b c) (d e)", func(f *sx.Format) { f.MaxTokens = 6 }, sx.ErrTooManyTokens},
+	{"(a b c) (d e)", func(f *sx.Format) { f.MaxInputBytes = 8 }, sx.ErrInputTooLarge},
+	{"(a b c d)", func(f *sx.Format) { f.MaxListLength = 3 }, sx.ErrListTooLong},
+	{"(a b c) (d e)", func(f *sx.Format) { f.MaxListLength = 3; f.MaxTokens = 7; f.MaxInputBytes = 13 }, nil},
+}
+
+func TestLimits(t *testing.T) {
+	for _, c := range limitCases {
+		f := sx.SX
+		c.Limit(&f)
+		_, err := f.Parse([]byte(c.In))
+		if err != c.Err {
+			t.Errorf("unexpected result: %s: %v != %v", c.In, err, c.Err)
+		}
+	}
+}
+
+type eofCase struct {
+	In        string
+	Construct string
+	Offset    int64
+}
+
+var eofCases = []eofCase{
+	{"(a b", "list", 0},
+	{"(a (b c) (d", "list", 9},
+	{`foo "bar`, "quoted string", 4},
+	{`5"abc`, "quoted string", 0},
+	{"  |YWJj", "base64 string", 2},
+	{"(#6162", "hex string", 1},
+	{"5:abc", "verbatim string", 0},
+	{"a {MTph", "verbatim base64 string", 2},
+}
+
+func TestEOF(t *testing.T) {
+	for _, c := range eofCases {
+		_, err := sx.SX.Parse([]byte(c.In))
+		e, ok := err.(*sx.EOFError)
+		if !ok {
+			t.Errorf("expected EOF error: %s: %v", c.In, err)
+			continue
+		}
+
+		if e.Construct != c.Construct || e.Offset != c.Offset {
+			t.Errorf("unexpected EOF error: %s: %v", c.In, err)
+		}
+
+		if !errors.Is(err, sx.ErrUnexpectedEOF) {
+			t.Errorf("EOF error does not unwrap: %s", c.In)
+		}
+	}
+
+	for _, s := range []string{"(a b)", "5:abcde", "0:", "abc", "-", "42", "{MTph}"} {
+		_, err := sx.SX.Parse([]byte(s))
+		if err != nil {
+			t.Errorf("unexpected error: %s: %v", s, err)
+		}
+	}
+}
+
+func TestLengthMismatch(t *testing.T) {
+	for _, s := range []string{`3"abcd"`, `5"abc"`, `3|YWJjZA==|`, `3#61#`, `1"é"`, `3"é"`, `2"aé"`} {
+		_, err := sx.SX.Parse([]byte(s))
+		if err != sx.ErrLengthMismatch {
+			t.Errorf("expected length mismatch: %s: %v", s, err)
+		}
+	}
+
+	xs, err := sx.SX.Parse([]byte(`3"abc" 3:abc #6162# |YQ==|`))
+	if err != nil || len(xs) != 4 {
+		t.Errorf("unexpected result: %v, %v", xs, err)
+	}
+
+	// Length prefixes count bytes, not characters.
+	xs, err = sx.SX.Parse([]byte(`2"é" 2:é 3"aé"`))
+	if err != nil || !sx.Equal(xs, []interface{}{"é", "é", "aé"}) {
+		t.Errorf("unexpected result: %v, %v", xs, err)
+	}
+}
+
+func TestWriteIndent(t *testing.T) {
+	xs, err := sx.SX.Parse([]byte(`(certificate (issuer (name (public-key rsa-with-md5 (e |NFGq/E3wh9f4rJIQVXhS|)) aid-committee)) (subject (ref tom mother)) (not-before "1997-01-01_09:00:00")) (short list)`))
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+
+	var b bytes.Buffer
+	err = sx.SX.WriteIndent(xs, &b)
+	if err != nil {
+		t.Fatalf("cannot indent: %v", err)
+	}
+
+	expected := `(certificate
+  (issuer
+    (name (public-key rsa-with-md5 (e |NFGq/E3wh9f4rJIQVXhS|))aid-committee))
+  (subject (ref tom mother))
+  (not-before "1997-01-01_09:00:00"))
+(short list)
+`
+	if b.String() != expected {
+		t.Fatalf("mismatch: %s", b.String())
+	}
+
+	if err := sx.SXCanonical.WriteIndent(xs, &b); err != sx.ErrIndentCanonical {
+		t.Fatalf("expected error indenting canonical form: %v", err)
+	}
+}
+
+func TestComments(t *testing.T) {
+	xs, err := sx.SX.Parse([]byte("; leading\n(a ; trailing (\n b) ; end"))
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	if !sx.Equal(xs, []interface{}{[]interface{}{"a", "b"}}) {
+		t.Fatalf("mismatch: %#v", xs)
+	}
+
+	if _, err := sx.Csexp.Parse([]byte("; x\n(a)")); err == nil {
+		t.Fatalf("expected error for comment in csexp")
+	}
+}
+
+func TestFormatSource(t *testing.T) {
+	tests := []struct {
+		in, out string
+	}{
+		{"", ""},
+		{"(a   b\n c)", "(a b c)\n"},
+		{"(a 3:x y \"q\\\"\" |YWJj| #616263# {MzphYmM=} 3\"abc\")", "(a 3:x y \"q\\\"\" |YWJj| #616263# {MzphYmM=} 3\"abc\")\n"},
+		{"(a)\n\n\n\n(b)\n(c)", "(a)\n\n(b)\n(c)\n"},
+		{"(a ; note\n  b)", "(a ; note\n  b)\n"},
+		{"(a b ; note\n)", "(a b ; note\n)\n"},
+		{"(a\n; own line\nb)", "(a\n  ; own line\n  b)\n"},
+		{"; head\n(x) ; tail", "; head\n(x) ; tail\n"},
+		{
+			"(certificate (issuer (name (public-key rsa-with-md5 (e |NFGq/E3wh9f4rJIQVXhS|)) aid-committee)) (not-before \"1997-01-01_09:00:00\"))",
+			"(certificate\n  (issuer\n    (name (public-key rsa-with-md5 (e |NFGq/E3wh9f4rJIQVXhS|)) aid-committee))\n  (not-before \"1997-01-01_09:00:00\"))\n",
+		},
+		{strings.Repeat("(", 45) + strings.Repeat(")", 45), strings.Repeat("(", 45) + strings.Repeat(")", 45) + "\n"},
+	}
+
+	for _, tt := range tests {
+		out, err := sx.FormatSource([]byte(tt.in))
+		if err != nil {
+			t.Fatalf("cannot format %q: %v", tt.in, err)
+		}
+		if string(out) != tt.out {
+			t.Errorf("format %q: got %q, expected %q", tt.in, out, tt.out)
+		}
+
+		again, err := sx.FormatSource(out)
+		if err != nil || string(again) != string(out) {
+			t.Errorf("format %q: not idempotent: %q", tt.in, again)
+		}
+	}
+
+	if _, err := sx.FormatSource([]byte("(a")); err == nil {
+		t.Fatalf("expected error for invalid source")
+	}
+
+	// Verbatim base64 content which continues into the source after it.
+	for _, in := range []string{"{YWJj}a", "{YWJj}3:0a", "(x ; c\n{Mzo=} ab)"} {
+		if _, err := sx.SX.Parse([]byte(in)); err != nil {
+			t.Fatalf("cannot parse %q: %v", in, err)
+		}
+		if _, err := sx.FormatSource([]byte(in)); !errors.Is(err, sx.ErrUnformattable) {
+			t.Errorf("format %q: expected ErrUnformattable: %v", in, err)
+		}
+	}
+}
+
+func TestQuotePrefixes(t *testing.T) {
+	f := sx.SX
+	f.ReadQuotePrefixes = true
+	f.WriteQuotePrefixes = true
+
+	xs, err := f.Parse([]byte("('a `(b ,c ,@d) ' e ''f)"))
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+
+	expected := parseOne(t, `((quote a) (quasiquote (b (unquote c) (unquote-splicing d))) (quote e) (quote (quote f)))`)
+	if !sx.Equal(xs, []interface{}{expected}) {
+		t.Fatalf("mismatch: %v", xs)
+	}
+
+	s, err := f.String(xs)
+	if err != nil {
+		t.Fatalf("cannot write: %v", err)
+	}
+	if s != "('a `(b ,c ,@d)'e ''f)" {
+		t.Fatalf("unexpected output: %s", s)
+	}
+
+	s, err = sx.SX.String(xs)
+	if err != nil || s != "((quote a)(quasiquote (b (unquote c)(unquote-splicing d)))(quote e)(quote (quote f)))" {
+		t.Fatalf("unexpected output without prefixes: %s", s)
+	}
+
+	// Nested prefix forms followed by atoms must remain separated.
+	for _, in := range []string{
+		`((quote (quote f)) g)`,
+		`((unquote x) g)`,
+		`((quote (quasiquote (unquote-splicing y))) 1 "s" (quote (quote (a))) b)`,
+	} {
+		v := parseOne(t, in)
+		s, err := f.String([]interface{}{v})
+		if err != nil {
+			t.Fatalf("cannot write: %v", err)
+		}
+		ys, err := f.Parse([]byte(s))
+		if err != nil || !sx.Equal(ys, []interface{}{v}) {
+			t.Errorf("round trip failed: %s -> %s -> %v %v", in, s, ys, err)
+		}
+	}
+
+	for _, in := range []string{"'", "(a ')", "'a"} {
+		if _, err := sx.SX.Parse([]byte(in)); err == nil {
+			t.Errorf("expected error without prefixes: %q", in)
+		}
+	}
+
+	if _, err := f.Parse([]byte("(a ')")); err == nil {
+		t.Errorf("expected error for prefix without value")
+	}
+	if _, err := f.Parse([]byte("'")); !errors.Is(err, sx.ErrUnexpectedEOF) {
+		t.Errorf("expected unexpected EOF: %v", err)
+	}
+}
+
+func TestTextPolicy(t *testing.T) {
+	vs := []interface{}{"café", "naïve text", "\x01\x02\x03a", "a\x00b", "app\xeeae", "tab\there"}
+
+	s, err := sx.SX.String(vs)
+	if err != nil {
+		t.Fatalf("cannot write: %v", err)
+	}
+	if s != `"café" "naïve text" |AQIDYQ==| |YQBi| |YXBw7mFl| "tab\there"` {
+		t.Fatalf("unexpected output: %s", s)
+	}
+
+	f := sx.SX
+	f.TextPolicy = sx.TextASCII
+	f.BinaryHex = true
+	s, err = f.String(vs)
+	if err != nil {
+		t.Fatalf("cannot write: %v", err)
+	}
+	if s != `"caf\xc3\xa9" "na\xc3\xafve text" #01020361# #610062# #617070ee6165# "tab\there"` {
+		t.Fatalf("unexpected output: %s", s)
+	}
+
+	for _, f := range []sx.Format{sx.SX, sx.Csexp, f} {
+		out, err := f.String(vs)
+		if err != nil {
+			t.Fatalf("cannot write: %v", err)
+		}
+		xs, err := sx.SX.Parse([]byte(out))
+		if err != nil || !sx.Equal(xs, vs) {
+			t.Errorf("round trip failed: %s: %q", out, xs)
+		}
+	}
+
+	xs, err := sx.Csexp.Parse([]byte(`"café"`))
+	if err != nil || !sx.Equal(xs, []interface{}{"café"}) {
+		t.Errorf("unexpected csexp parse: %q %v", xs, err)
+	}
+}
+
+func TestLineContinuation(t *testing.T) {
+	for in, out := range map[string]string{
+		"\"a\\\nb\"":   "ab",
+		"\"a\\\n\"":    "a",
+		"\"a\\\n\rb\"": "ab",
+		"\"a\\\r\nb\"": "ab",
+		"\"a\\\rb\"":   "ab",
+	} {
+		xs, err := sx.SX.Parse([]byte(in))
+		if err != nil || !sx.Equal(xs, []interface{}{out}) {
+			t.Errorf("unexpected result: %q: %q %v", in, xs, err)
+		}
+	}
+}
+
+func TestUnicodeEscapes(t *testing.T) {
+	xs, err := sx.SX.Parse([]byte(`"café" "\U0001F600" "\u{1F600}\u{41}" "\x41\101"`))
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	if !sx.Equal(xs, []interface{}{"café", "\U0001F600", "\U0001F600A", "AA"}) {
+		t.Fatalf("mismatch: %q", xs)
+	}
+
+	for _, in := range []string{`"\uD800"`, `"\U00110000"`, `"\u{DFFF}"`} {
+		_, err := sx.SX.Parse([]byte(in))
+		if !errors.Is(err, sx.ErrInvalidCodePoint) {
+			t.Errorf("expected invalid code point: %s: %v", in, err)
+		}
+	}
+
+	for _, in := range []string{`"\u12"`, `"\u{}"`, `"\u{1234567}"`, `"\uzzzz"`, `"\xzz"`} {
+		if _, err := sx.SX.Parse([]byte(in)); err == nil {
+			t.Errorf("expected error: %s", in)
+		}
+	}
+
+	f := sx.SX
+	f.TextPolicy = sx.TextUnicodeEscape
+	vs := []interface{}{"café \U0001F600"}
+	s, err := f.String(vs)
+	if err != nil || s != `"caf\u00e9 \U0001f600"` {
+		t.Fatalf("unexpected output: %s %v", s, err)
+	}
+
+	xs, err = sx.SX.Parse([]byte(s))
+	if err != nil || !sx.Equal(xs, vs) {
+		t.Errorf("round trip failed: %q %v", xs, err)
+	}
+}
+
+func TestInvalidUTF8(t *testing.T) {
+	in := []byte("(a \"x\xffy\" 3:\xff\xfe\xfd)")
+
+	xs, err := sx.SX.Parse(in)
+	if err != nil || !sx.Equal(xs, []interface{}{[]interface{}{"a", "x�y", "\xff\xfe\xfd"}}) {
+		t.Errorf("unexpected result with replacement: %q %v", xs, err)
+	}
+
+	f := sx.SX
+	f.InvalidUTF8 = sx.InvalidUTF8Preserve
+	xs, err = f.Parse(in)
+	if err != nil || !sx.Equal(xs, []interface{}{[]interface{}{"a", "x\xffy", "\xff\xfe\xfd"}}) {
+		t.Errorf("unexpected result with preservation: %q %v", xs, err)
+	}
+
+	f.InvalidUTF8 = sx.InvalidUTF8Strict
+	_, err = f.Parse(in)
+	if !errors.Is(err, sx.ErrInvalidUTF8) || !strings.Contains(err.Error(), "offset 5") {
+		t.Errorf("expected invalid UTF-8 error at offset 5: %v", err)
+	}
+
+	xs, err = f.Parse([]byte("(a 3:\xff\xfe\xfd |/w==|)"))
+	if err != nil || len(xs) != 1 {
+		t.Errorf("binary atoms should not be checked: %v", err)
+	}
+
+	// Characters split across writes.
+	p := f.NewParser()
+	for _, c := range []byte(`("café" ; ☺` + "\n)") {
+		if _, err := p.Write([]byte{c}); err != nil {
+			t.Fatalf("cannot write: %v", err)
+		}
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("cannot close: %v", err)
+	}
+	if xs := p.Tokens(); !sx.Equal(xs, []interface{}{[]interface{}{"café"}}) {
+		t.Errorf("unexpected result for split characters: %q", xs)
+	}
+
+	p = f.NewParser()
+	p.Write([]byte("\"a\xc3"))
+	if _, err := p.Write([]byte("\"")); !errors.Is(err, sx.ErrInvalidUTF8) {
+		t.Errorf("expected invalid UTF-8 error for truncated character: %v", err)
+	}
+}
+
+// A canonical document of n certificates, roughly 400 bytes each.
+func benchDocument(n int) []byte {
+	var vs []interface{}
+	key := string(bytes.Repeat([]byte{0xA5}, 128))
+	for i := 0; i < n; i++ {
+		vs = append(vs, []interface{}{
+			"cert",
+			[]interface{}{"issuer", []interface{}{"public-key", []interface{}{"rsa", []interface{}{"e", "\x01\x00\x01"}, []interface{}{"n", key}}}},
+			[]interface{}{"subject", []interface{}{"hash", "sha256", key[:32]}},
+			[]interface{}{"valid", []interface{}{"not-before", "2020-01-01_00:00:00"}, []interface{}{"not-after", "2030-01-01_00:00:00"}},
+			[]interface{}{"tag", []interface{}{"spend", []interface{}{"account", "12345678"}, []interface{}{"*", "range", "numeric", "1", "1000"}}},
+			i,
+		})
+	}
+
+	s, err := sx.SXCanonical.String(vs)
+	if err != nil {
+		panic(err)
+	}
+	return []byte(s)
+}
+
+func BenchmarkParseCanonical(b *testing.B) {
+	doc := benchDocument(10000)
+	b.SetBytes(int64(len(doc)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := sx.Csexp.Parse(doc); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseCanonicalZeroCopy(b *testing.B) {
+	f := sx.Csexp
+	f.ZeroCopy = true
+	doc := benchDocument(10000)
+	b.SetBytes(int64(len(doc)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := f.Parse(doc); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseAdvanced(b *testing.B) {
+	vs, err := sx.Csexp.Parse(benchDocument(10000))
+	if err != nil {
+		b.Fatal(err)
+	}
+	s, err := sx.SX.String(vs)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	doc := []byte(s)
+	b.SetBytes(int64(len(doc)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := sx.SX.Parse(doc); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseLongAtoms(b *testing.B) {
+	long := bytes.Repeat([]byte("abcdefgh"), 1<<17)
+	vs := []interface{}{string(long)}
+	verbatim, _ := sx.SXCanonical.String(vs)
+	quoted := `"` + string(long) + `"`
+	b64 := "|" + base64.StdEncoding.EncodeToString(long) + "|"
+
+	for _, c := range []struct {
+		name string
+		doc  string
+	}{{"Verbatim", verbatim}, {"Quoted", quoted}, {"Base64", b64}} {
+		doc := []byte(c.doc)
+		b.Run(c.name, func(b *testing.B) {
+			b.SetBytes(int64(len(doc)))
+			for i := 0; i < b.N; i++ {
+				if _, err := sx.SX.Parse(doc); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestZeroCopy(t *testing.T) {
+	in := []byte(`(cert (issuer 5:alice) (tag "x y" |AAE=| -n 42) end)`)
+
+	f := sx.SX
+	f.ZeroCopy = true
+	xs, err := f.Parse(in)
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+
+	expected, _ := sx.SX.Parse(in)
+	if !sx.Equal(xs, expected) {
+		t.Fatalf("mismatch: %q", xs)
+	}
+
+	cert := xs[0].([]interface{})
+	if _, ok := cert[0].([]byte); !ok {
+		t.Fatalf("expected token to be []byte: %T", cert[0])
+	}
+	if !sx.Hhy(cert, "cert") || sx.Q1bhy(cert[1:], "issuer") == nil {
+		t.Fatalf("head yarn queries should accept []byte heads")
+	}
+
+	tag := sx.Q1bhyt(cert[1:], "tag")
+	if _, ok := tag[0].(string); !ok {
+		t.Errorf("expected quoted string to be string: %T", tag[0])
+	}
+	if _, ok := tag[2].([]byte); !ok {
+		t.Errorf("expected token to be []byte: %T", tag[2])
+	}
+
+	// Values alias the input.
+	alice := sx.Q1bhyt(cert[1:], "issuer")[0].([]byte)
+	copy(in[16:], "ALICE")
+	if string(alice) != "ALICE" {
+		t.Errorf("expected value to alias input: %s", alice)
+	}
+
+	// Appending to a value must not overwrite the input.
+	_ = append(alice, 'x')
+	if in[21] != ')' {
+		t.Errorf("append overwrote input")
+	}
+}
+
+func TestParserReset(t *testing.T) {
+	p := sx.SX.NewParser()
+	if _, err := p.Write([]byte(`(a "unterminated`)); err != nil {
+		t.Fatalf("cannot write: %v", err)
+	}
+
+	p.Reset()
+	for _, in := range []string{`(a b) c`, `(d {KDE6eCk=})`} {
+		if _, err := p.Write([]byte(in)); err != nil {
+			t.Fatalf("cannot write: %v", err)
+		}
+		if err := p.Close(); err != nil {
+			t.Fatalf("cannot close: %v", err)
+		}
+
+		xs := p.Tokens()
+		p.Reset()
+		expected, _ := sx.SX.Parse([]byte(in))
+		if !sx.Equal(xs, expected) {
+			t.Errorf("mismatch: %s: %v", in, xs)
+		}
+	}
+
+	// Results of Parse must not be affected by later calls.
+	xs, _ := sx.SX.Parse([]byte(`(a b) c`))
+	for i := 0; i < 10; i++ {
+		sx.SX.Parse([]byte(`(x y z) w v`))
+		sx.Csexp.Parse([]byte(`(1:x`))
+	}
+	if !sx.Equal(xs, []interface{}{[]interface{}{"a", "b"}, "c"}) {
+		t.Errorf("result was modified: %v", xs)
+	}
+}
+
+func TestParseReader(t *testing.T) {
+	inputs := []string{
+		`(d {KDE6eCk=}) {KDE6eCkoMTp5KQ==} { KDE6 eCk= }`,
+		`(a "q\\\"\x41\101\u00e9\u{1F600}\
+" 3"abc" |YWJj| #616263# 5:ab cd)`,
+		`("café ☺" ; comment ☺` + "\n" + `x-y 42 (()))`,
+		`(3:rpc(6:method4:ping){KDI6aWQyOjQyKQ==})`,
+	}
+
+	for _, in := range inputs {
+		expected, err := sx.SX.Parse([]byte(in))
+		if err != nil {
+			t.Fatalf("cannot parse: %s: %v", in, err)
+		}
+
+		for _, r := range []func(r io.Reader) io.Reader{iotest.OneByteReader, iotest.HalfReader, iotest.DataErrReader} {
+			xs, err := sx.SX.ParseReader(r(strings.NewReader(in)))
+			if err != nil {
+				t.Errorf("cannot parse from reader: %s: %v", in, err)
+			} else if !sx.Equal(xs, expected) {
+				t.Errorf("mismatch: %s: %v", in, xs)
+			}
+		}
+	}
+
+	for _, in := range []string{`{MTp}`, `{KDE6eCk=`, `(a`} {
+		_, err := sx.SX.ParseReader(iotest.OneByteReader(strings.NewReader(in)))
+		if err == nil {
+			t.Errorf("expected error: %s", in)
+		}
+	}
+
+	_, err := sx.SX.ParseReader(iotest.TimeoutReader(strings.NewReader(`(a b)`)))
+	if !errors.Is(err, iotest.ErrTimeout) {
+		t.Errorf("expected read error: %v", err)
+	}
+}
+
+func BenchmarkParseSmall(b *testing.B) {
+	msg := []byte(`(3:rpc(6:method4:ping)(2:id2:42)(4:args(1:x1:1)(1:y1:2)))`)
+	b.SetBytes(int64(len(msg)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := sx.Csexp.Parse(msg); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
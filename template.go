@@ -0,0 +1,208 @@
+package sx
+
+import "fmt"
+import "sync"
+
+// Template for building values.
+//
+// A template is an S-expression in the SX format which may also contain
+// unquotes, in the manner of Lisp quasiquotation:
+//
+//   ,name    replaced by the value of the variable name
+//   ,@name   replaced by the elements of the variable name, which must be a
+//            list; only valid within a list
+//
+// For example, building the template
+//
+//   (server (name ,name) (ports ,@ports))
+//
+// with name = "alpha" and ports = []interface{}{80, 443} yields
+// (server (name alpha) (ports 80 443)).
+//
+// Unquotes are read as (unquote name) and (unquote-splicing name), so those
+// lists are also treated as unquotes if written out in full.
+type Template struct {
+	v interface{}
+}
+
+// Parses a template from its textual form, which must contain exactly one
+// value.
+func ParseTemplate(s string) (*Template, error) {
+	f := SX
+	f.allowUnquote = true
+
+	vs, err := f.Parse([]byte(s))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(vs) != 1 {
+		return nil, fmt.Errorf("template must contain exactly one value")
+	}
+
+	if name, splice, ok := templateUnquote(vs[0]); ok && splice {
+		return nil, fmt.Errorf("template: cannot splice %s outside a list", name)
+	}
+
+	err = checkTemplate(vs[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return &Template{v: vs[0]}, nil
+}
+
+func checkTemplate(v interface{}) error {
+	xs, ok := v.([]interface{})
+	if !ok {
+		return nil
+	}
+
+	if Hhy(v, "unquote") || Hhy(v, "unquote-splicing") {
+		if len(xs) != 2 {
+			return fmt.Errorf("template: malformed %s", xs[0])
+		}
+		if _, ok := atomString(xs[1]); !ok {
+			return fmt.Errorf("template: %s requires a variable name", xs[0])
+		}
+		return nil
+	}
+
+	for _, x := range xs {
+		if err := checkTemplate(x); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the variable name if v is an unquote.
+func templateUnquote(v interface{}) (name string, splice bool, ok bool) {
+	xs, isList := v.([]interface{})
+	if !isList || len(xs) != 2 {
+		return "", false, false
+	}
+
+	switch {
+	case Hhy(v, "unquote"):
+	case Hhy(v, "unquote-splicing"):
+		splice = true
+	default:
+		return "", false, false
+	}
+
+	name, ok = atomString(xs[1])
+	return name, splice, ok
+}
+
+// Builds a value from the template, substituting the given variables. Every
+// variable referenced by the template must be present. The template itself is
+// not modified and shares no lists with the result.
+func (t *Template) Build(vars map[string]interface{}) (interface{}, error) {
+	return buildTemplate(t.v, vars)
+}
+
+func buildTemplate(v interface{}, vars map[string]interface{}) (interface{}, error) {
+	if name, _, ok := templateUnquote(v); ok {
+		x, ok := vars[name]
+		if !ok {
+			return nil, fmt.Errorf("template: undefined variable: %s", name)
+		}
+		return x, nil
+	}
+
+	xs, ok := v.([]interface{})
+	if !ok {
+		return v, nil
+	}
+
+	out := make([]interface{}, 0, len(xs))
+	for _, x := range xs {
+		if name, splice, ok := templateUnquote(x); ok && splice {
+			ys, err := templateSplice(name, vars)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, ys...)
+			continue
+		}
+
+		y, err := buildTemplate(x, vars)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, y)
+	}
+	return out, nil
+}
+
+func templateSplice(name string, vars map[string]interface{}) ([]interface{}, error) {
+	x, ok := vars[name]
+	if !ok {
+		return nil, fmt.Errorf("template: undefined variable: %s", name)
+	}
+
+	switch xx := x.(type) {
+	case []interface{}:
+		return xx, nil
+	case []string:
+		ys := make([]interface{}, len(xx))
+		for i, s := range xx {
+			ys[i] = s
+		}
+		return ys, nil
+	default:
+		return nil, fmt.Errorf("template: cannot splice %s: not a list", name)
+	}
+}
+
+// Parses a template as ParseTemplate does, but panics if the template is
+// malformed. It is intended for templates which are constants, for example
+//
+//   var serverTemplate = sx.MustParseTemplate(`(server (name ,name))`)
+//
+func MustParseTemplate(s string) *Template {
+	t, err := ParseTemplate(s)
+	if err != nil {
+		panic(fmt.Sprintf("bad template: %v", err))
+	}
+	return t
+}
+
+// Parsed templates used by Build. The cache is emptied when it reaches
+// maxCachedTemplates entries, so that programs which construct template text
+// dynamically do not use unbounded memory.
+var templateCache = map[string]*Template{}
+var templateCacheMutex sync.Mutex
+
+const maxCachedTemplates = 256
+
+// Builds a value from a template given in textual form. Recently used
+// templates are cached, so calling Build repeatedly with the same constant
+// template is cheap. Templates constructed at run time should not be passed to
+// Build, since they would defeat the cache; use ParseTemplate instead.
+//
+// Returns an error if the template is malformed, or if a variable is missing
+// or cannot be spliced.
+func Build(template string, vars map[string]interface{}) (interface{}, error) {
+	templateCacheMutex.Lock()
+	t, ok := templateCache[template]
+	templateCacheMutex.Unlock()
+
+	if !ok {
+		var err error
+		t, err = ParseTemplate(template)
+		if err != nil {
+			return nil, err
+		}
+
+		templateCacheMutex.Lock()
+		if len(templateCache) >= maxCachedTemplates {
+			templateCache = map[string]*Template{}
+		}
+		templateCache[template] = t
+		templateCacheMutex.Unlock()
+	}
+
+	return t.Build(vars)
+}
@@ -0,0 +1,88 @@
+package sx_test
+
+import "errors"
+import "fmt"
+import "testing"
+import "github.com/hlandau/sx"
+
+func TestBuild(t *testing.T) {
+	vars := map[string]interface{}{
+		"name":  "alpha",
+		"ports": []interface{}{80, 443},
+		"tls":   []interface{}{"tls", []interface{}{"cert", "a.pem"}},
+		"none":  []interface{}{},
+	}
+
+	cases := []struct {
+		Template, Out string
+	}{
+		{`(server (name ,name) (ports ,@ports))`, `(server (name alpha) (ports 80 443))`},
+		{`(server ,tls ,@none)`, `(server (tls (cert a.pem)))`},
+		{`(a , name (b ,@ ports))`, `(a alpha (b 80 443))`},
+		{`(a (unquote name))`, `(a alpha)`},
+		{`,name`, `alpha`},
+	}
+
+	for _, c := range cases {
+		for i := 0; i < 2; i++ {
+			v, err := sx.Build(c.Template, vars)
+			if err != nil {
+				t.Fatalf("cannot build: %s: %v", c.Template, err)
+			}
+			if !sx.Equal(v, parseOne(t, c.Out)) {
+				t.Errorf("unexpected result: %s: %v", c.Template, v)
+			}
+		}
+	}
+
+	// The result must not share lists with the template.
+	v, _ := sx.Build(`(a (b))`, nil)
+	v.([]interface{})[1].([]interface{})[0] = "x"
+	v, _ = sx.Build(`(a (b))`, nil)
+	if !sx.Equal(v, parseOne(t, `(a (b))`)) {
+		t.Errorf("template was modified: %v", v)
+	}
+
+	for _, tmpl := range []string{`(a ,missing)`, `(a ,@name)`, `(a ,@missing)`} {
+		if _, err := sx.Build(tmpl, vars); err == nil {
+			t.Errorf("expected error: %s", tmpl)
+		}
+	}
+}
+
+func TestParseTemplate(t *testing.T) {
+	for _, tmpl := range []string{`,@xs`, `(a ,)`, `(a ,(b))`, `(a) (b)`, `(unquote a b)`} {
+		if _, err := sx.ParseTemplate(tmpl); err == nil {
+			t.Errorf("expected error: %s", tmpl)
+		}
+	}
+
+	_, err := sx.ParseTemplate(`(a ,`)
+	if !errors.Is(err, sx.ErrUnexpectedEOF) {
+		t.Errorf("expected unexpected EOF: %v", err)
+	}
+
+	if _, err := sx.SX.Parse([]byte(`(a ,b)`)); err == nil {
+		t.Errorf("expected unquote to be rejected outside templates")
+	}
+
+	if _, err := sx.Build(`(a ,`, nil); !errors.Is(err, sx.ErrUnexpectedEOF) {
+		t.Errorf("expected unexpected EOF from Build: %v", err)
+	}
+
+	// Dynamically constructed templates must still work once the cache is
+	// full.
+	for i := 0; i < 1000; i++ {
+		v, err := sx.Build(fmt.Sprintf("(a %d)", i), nil)
+		if err != nil || !sx.Equal(v, []interface{}{"a", i}) {
+			t.Fatalf("unexpected result: %v %v", v, err)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected panic for malformed template")
+		}
+	}()
+	sx.MustParseTemplate(`(a ,`)
+}
@@ -38,10 +38,14 @@ func Q1bhyt(xs []interface{}, s string) []interface{} {
 // Has head yarn?
 //
 // Returns true iff v is of the form (s ...), where s is the string given.
+// The head may be a string or a []byte.
 func Hhy(v interface{}, s string) bool {
 	if xs, ok := v.([]interface{}); ok && len(xs) > 0 {
-		if ss, ok := xs[0].(string); ok && ss == s {
-			return true
+		switch h := xs[0].(type) {
+		case string:
+			return h == s
+		case []byte:
+			return string(h) == s
 		}
 	}
 	return false
@@ -86,3 +90,62 @@ func Q1bsyt(xs []interface{}, sel string) []interface{} {
 
 	return cur
 }
+
+// Structural equality.
+//
+// Returns true iff a and b represent the same S-expression. Strings and []byte
+// compare equal if they have the same bytes, and integers compare equal if
+// they have the same value regardless of which Go type holds them.
+func Equal(a, b interface{}) bool {
+	switch av := a.(type) {
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for i := range av {
+			if !Equal(av[i], bv[i]) {
+				return false
+			}
+		}
+		return true
+	case string, []byte:
+		as, ok := atomString(a)
+		bs, ok2 := atomString(b)
+		return ok && ok2 && as == bs
+	default:
+		ai, aneg, ok := atomInt(a)
+		bi, bneg, ok2 := atomInt(b)
+		return ok && ok2 && ai == bi && aneg == bneg
+	}
+}
+
+func atomString(v interface{}) (string, bool) {
+	switch vv := v.(type) {
+	case string:
+		return vv, true
+	case []byte:
+		return string(vv), true
+	default:
+		return "", false
+	}
+}
+
+// Returns the magnitude and sign of an integer value.
+func atomInt(v interface{}) (uint64, bool, bool) {
+	var x int64
+	switch vv := v.(type) {
+	case int:
+		x = int64(vv)
+	case int64:
+		x = vv
+	case uint64:
+		return vv, false, true
+	default:
+		return 0, false, false
+	}
+	if x < 0 {
+		return uint64(-x), true, true
+	}
+	return uint64(x), false, true
+}